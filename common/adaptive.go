@@ -0,0 +1,205 @@
+package common
+
+// Adaptive Huffman Tree, kept up to date with Vitter's algorithm as symbols are coded.
+// The encoder and decoder make the same updates, so the tree never has to be stored.
+
+// The public interface
+type AdaptiveTree interface {
+	Code(symbol byte, w *BitWriter)
+	Decode(r *BitReader) (byte, error)
+	Update(symbol byte)
+}
+
+// The most nodes the tree can have, every symbol plus the NYT node as leaves
+const MAX_ADAPTIVE_NODES = 2*(ALPHABET_SIZE+1) - 1
+
+// A node in the adaptive tree
+type adaptiveNode struct {
+	weight int
+	number int // The node's place in the implicit numbering, higher numbers never have lower weights
+	symbol byte
+	leaf   bool
+	parent *adaptiveNode
+	left   *adaptiveNode
+	right  *adaptiveNode
+}
+
+// Internal Tree Struct
+type adaptiveTree struct {
+	root   *adaptiveNode
+	nyt    *adaptiveNode // The "not yet transmitted" leaf that new symbols are split off from
+	leaves [ALPHABET_SIZE]*adaptiveNode
+	order  [MAX_ADAPTIVE_NODES]*adaptiveNode // Every node by its implicit number
+	path   []byte                            // The path to the leaf being coded, from the leaf up
+}
+
+// Code writes the code for a symbol, a symbol that hasn't been seen yet is coded as the path to
+// the NYT node followed by the raw symbol
+// symbol: The symbol to code
+// w: The bit writer to write the code to
+func (t *adaptiveTree) Code(symbol byte, w *BitWriter) {
+	n := t.leaves[symbol]
+	if n == nil {
+		n = t.nyt
+	}
+
+	// The path is found from the leaf up, so collect it and write it out in reverse
+	t.path = t.path[:0]
+	for ; n.parent != nil; n = n.parent {
+		if n == n.parent.right {
+			t.path = append(t.path, 1)
+		} else {
+			t.path = append(t.path, 0)
+		}
+	}
+	for i := len(t.path) - 1; i >= 0; i-- {
+		w.WriteBits(uint64(t.path[i]), 1)
+	}
+
+	if t.leaves[symbol] == nil {
+		w.WriteBits(uint64(symbol), 8)
+	}
+}
+
+// Decode reads the next symbol, walking the tree one bit at a time
+// r: The bit reader to read the code from
+func (t *adaptiveTree) Decode(r *BitReader) (byte, error) {
+	n := t.root
+	for !n.leaf {
+		bit, err := r.ReadBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+	if n != t.nyt {
+		return n.symbol, nil
+	}
+
+	// New symbols follow the NYT code as raw bits
+	symbol, err := r.ReadBits(8)
+	return byte(symbol), err
+}
+
+// Update adds one to the weight of a symbol and reorders the tree to keep it a Huffman tree
+// symbol: The symbol that was just coded
+func (t *adaptiveTree) Update(symbol byte) {
+	var leafToIncrement *adaptiveNode
+	n := t.leaves[symbol]
+
+	if n == nil {
+		// Split the NYT node into a new NYT node and a leaf for the symbol
+		n = t.nyt
+		n.leaf = false
+		n.left = t.addNode(&adaptiveNode{leaf: true, parent: n}, n.number-2)
+		n.right = t.addNode(&adaptiveNode{leaf: true, symbol: symbol, parent: n}, n.number-1)
+		t.nyt = n.left
+		t.leaves[symbol] = n.right
+		leafToIncrement = n.right
+	} else {
+		// Move the leaf to the top of its block, a leaf next to the NYT node is left until last
+		// because its parent shares its weight
+		t.swap(n, t.blockLeader(n))
+		if n.parent != nil && n.parent.left == t.nyt {
+			leafToIncrement = n
+			n = n.parent
+		}
+	}
+
+	for n != nil {
+		n = t.slideAndIncrement(n)
+	}
+	if leafToIncrement != nil {
+		t.slideAndIncrement(leafToIncrement)
+	}
+}
+
+// slideAndIncrement slides a node past the block of nodes it would otherwise break the ordering
+// of, then adds one to its weight. Returns the next node up the tree to update.
+// n: The node to increment
+func (t *adaptiveTree) slideAndIncrement(n *adaptiveNode) *adaptiveNode {
+	formerParent := n.parent
+	weight := n.weight
+
+	// Leaves slide past internal nodes of the same weight, internal nodes slide past leaves
+	// that are heavier by one
+	for n.number+1 < len(t.order) {
+		next := t.order[n.number+1]
+		if n.leaf && (next.leaf || next.weight != weight) {
+			break
+		}
+		if !n.leaf && (!next.leaf || next.weight != weight+1) {
+			break
+		}
+		t.swap(n, next)
+	}
+
+	n.weight++
+	if n.leaf {
+		return n.parent
+	}
+	return formerParent
+}
+
+// blockLeader returns the highest numbered node with the same weight and type as n
+// n: The node whose block to search
+func (t *adaptiveTree) blockLeader(n *adaptiveNode) *adaptiveNode {
+	leader := n
+	for leader.number+1 < len(t.order) {
+		next := t.order[leader.number+1]
+		if next.weight != n.weight || next.leaf != n.leaf {
+			break
+		}
+		leader = next
+	}
+	return leader
+}
+
+// swap exchanges the places of two nodes in the tree and in the numbering, neither node may be
+// an ancestor of the other
+// a: The first node
+// b: The second node
+func (t *adaptiveTree) swap(a *adaptiveNode, b *adaptiveNode) {
+	if a == b {
+		return
+	}
+
+	// Point each parent at the other node
+	aParent, bParent := a.parent, b.parent
+	aIsLeft, bIsLeft := aParent.left == a, bParent.left == b
+	if aIsLeft {
+		aParent.left = b
+	} else {
+		aParent.right = b
+	}
+	if bIsLeft {
+		bParent.left = a
+	} else {
+		bParent.right = a
+	}
+	a.parent, b.parent = bParent, aParent
+
+	a.number, b.number = b.number, a.number
+	t.order[a.number], t.order[b.number] = a, b
+}
+
+// addNode puts a new node into the numbering
+// n: The new node
+// number: The node's implicit number
+func (t *adaptiveTree) addNode(n *adaptiveNode, number int) *adaptiveNode {
+	n.number = number
+	t.order[number] = n
+	return n
+}
+
+// NewAdaptiveTree returns a tree that only holds the NYT node
+func NewAdaptiveTree() AdaptiveTree {
+	t := &adaptiveTree{}
+	t.root = t.addNode(&adaptiveNode{leaf: true}, MAX_ADAPTIVE_NODES-1)
+	t.nyt = t.root
+	return t
+}
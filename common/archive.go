@@ -0,0 +1,84 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Archives hold a compressed stream for every file, followed by a central directory that
+// describes every entry so archives can be listed without decoding any data
+
+const ARCHIVE_MAGIC_NUMBER = 0xA2C4BEEF   // Magic number at the start of an archive.
+const DIRECTORY_MAGIC_NUMBER = 0xD12EBEEF // Magic number at the very end of an archive's central directory.
+const ARCHIVE_VERSION = 1                 // Version of the archive layout written by this build.
+const MAX_ARCHIVE_PATH = 1<<16 - 1        // The longest path or link target an entry can have.
+
+// Kinds of archive entries
+const ENTRY_FILE = 0
+const ENTRY_DIRECTORY = 1
+const ENTRY_SYMLINK = 2
+
+// An entry of an archive
+type ArchiveEntry struct {
+	Path       string // The slash separated path of the entry, relative to where it's extracted
+	Type       uint8  // The kind of entry
+	Mode       uint32 // The entry's permission bits
+	ModTime    int64  // When the entry was last modified, in nanoseconds since the Unix epoch
+	Size       uint64 // The size of a file's data, 0 for other entries
+	LinkTarget string // Where a symlink points
+}
+
+// The header at the start of an archive
+type ArchiveHeader struct {
+	MagicNumber uint32
+	Version     uint8
+}
+
+// An entry in the central directory, the entry's path and link target follow it
+type DirectoryEntry struct {
+	Type       uint8
+	Mode       uint32
+	ModTime    int64
+	Size       uint64 // The size of the entry's data once decoded
+	DataOffset uint64 // Where the entry's compressed stream starts, in bytes from the start of the archive
+	DataSize   uint64 // The size of the entry's compressed stream, 0 for entries without data
+	PathLength uint16
+	LinkLength uint16
+}
+
+// The footer that ends an archive, the central directory comes right before it
+type DirectoryFooter struct {
+	EntryCount        uint32
+	DirectoryOffset   uint64 // Where the central directory starts, in bytes from the start of the archive
+	DirectoryChecksum uint32 // The checksum of the central directory
+	MagicNumber       uint32
+}
+
+// CreateArchiveHeader creates an archive header
+func CreateArchiveHeader() *ArchiveHeader {
+	return &ArchiveHeader{ARCHIVE_MAGIC_NUMBER, ARCHIVE_VERSION}
+}
+
+// CreateDirectoryFooter creates a central directory footer
+// entryCount: The number of entries in the directory
+// directoryOffset: Where the directory starts
+// directory: The directory's bytes
+func CreateDirectoryFooter(entryCount uint32, directoryOffset uint64, directory []byte) *DirectoryFooter {
+	return &DirectoryFooter{entryCount, directoryOffset, DirectoryChecksum(directory), DIRECTORY_MAGIC_NUMBER}
+}
+
+// CheckArchivePath makes sure an entry path is relative and stays inside the directory it's
+// extracted to
+// name: The slash separated path of the entry
+func CheckArchivePath(name string) error {
+	if name == "" || len(name) > MAX_ARCHIVE_PATH || strings.ContainsRune(name, 0) {
+		return errors.New("invalid archive entry path")
+	}
+	if path.IsAbs(name) || strings.Contains(name, "\\") || path.Clean(name) != name ||
+		name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("archive entry path %q is not a clean relative path", name)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package common
+
+import "io"
+
+// The most bits Peek and ReadBits can return, the register is refilled a whole byte at a time
+const MAX_PEEK_BITS = 56
+
+// BitReader reads a stream of bytes written by a BitWriter a few bits at a time, the first bit of
+// the stream is the lowest bit of the register. It's a concrete type rather than an interface so
+// the calls in decoding loops can be inlined.
+type BitReader struct {
+	r   io.ByteReader
+	acc uint64 // Bits that have been read but not consumed
+	n   uint   // The number of valid bits in acc
+	err error  // The error that stopped the last refill
+}
+
+// NewBitReader returns a BitReader that reads from r
+// r: The reader to read the bits from
+func NewBitReader(r io.ByteReader) *BitReader {
+	return &BitReader{r: r}
+}
+
+// Refill reads whole bytes into the register until it's nearly full or the stream runs out
+func (br *BitReader) Refill() {
+	for br.n <= MAX_PEEK_BITS && br.err == nil {
+		b, err := br.r.ReadByte()
+		if err != nil {
+			br.err = err
+			return
+		}
+		br.acc |= uint64(b) << br.n
+		br.n += 8
+	}
+}
+
+// Buffered returns the number of bits that have been read into the register but not consumed
+func (br *BitReader) Buffered() uint {
+	return br.n
+}
+
+// Peek returns the next n bits without consuming them, refilling the register if it holds fewer.
+// Bits past the end of the stream read as zeros, Buffered says how many are real.
+// n: The number of bits to peek at, at most MAX_PEEK_BITS
+func (br *BitReader) Peek(n uint) uint64 {
+	if br.n < n {
+		br.Refill()
+	}
+	return br.acc & (1<<n - 1)
+}
+
+// Consume drops bits from the register
+// n: The number of bits to drop, at most Buffered
+func (br *BitReader) Consume(n uint) {
+	br.acc >>= n
+	br.n -= n
+}
+
+// Err returns the error that stopped the register being refilled, io.EOF at the end of the stream
+func (br *BitReader) Err() error {
+	return br.err
+}
+
+// ReadBits reads an n bit value, least significant bit first. Running out of bits part way
+// through the value returns io.ErrUnexpectedEOF.
+// n: The number of bits to read, at most MAX_PEEK_BITS
+func (br *BitReader) ReadBits(n uint) (uint64, error) {
+	value := br.Peek(n)
+	if br.n < n {
+		if br.err == nil || br.err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, br.err
+	}
+	br.Consume(n)
+	return value, nil
+}
+
+// ReadByte reads the next 8 bits as a byte, io.EOF is only returned if there are no bits left
+func (br *BitReader) ReadByte() (byte, error) {
+	if br.n < 8 {
+		br.Refill()
+		if br.n == 0 && br.err == io.EOF {
+			return 0, io.EOF
+		}
+	}
+	b, err := br.ReadBits(8)
+	return byte(b), err
+}
+
+// Read reads whole bytes, for fixed size fields in the middle of a bit stream that's at a byte boundary
+// p: The buffer to read into
+func (br *BitReader) Read(p []byte) (int, error) {
+	for i := range p {
+		b, err := br.ReadByte()
+		if err != nil {
+			return i, err
+		}
+		p[i] = b
+	}
+	return len(p), nil
+}
+
+// AlignToByte drops the bits left in the current byte
+func (br *BitReader) AlignToByte() {
+	br.Consume(br.n % 8)
+}
@@ -2,7 +2,11 @@ package common
 
 import "bytes"
 
-// Public Interface
+// BitStack is a stack of bits in a BitVec, codes were built and written with it one bit at a time.
+//
+// Deprecated: Use BitWriter and BitReader, which move whole words at a time. BitStack is no longer
+// used by compress or decompress, it stays for code outside the module and as the baseline of the
+// BitWriter benchmarks.
 type BitStack interface {
 	Push(bit byte)
 	Pop() bool
@@ -2,7 +2,10 @@ package common
 
 const BITS = 8
 
-// The Public Interface
+// BitVec is a vector of bits that's read and written one bit at a time.
+//
+// Deprecated: Use BitWriter and BitReader. BitVec is no longer used by compress or decompress, it
+// stays for code outside the module and as the baseline of the BitReader benchmarks.
 type BitVec interface {
 	SetBit(i int)
 	ClrBit(i int)
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// BitWriter packs values into a stream of bytes, the first bit written is the lowest bit of the
+// first byte. Bits are gathered in a 64 bit register and written a whole word at a time. It's a
+// concrete type rather than an interface so the calls in coding loops can be inlined.
+type BitWriter struct {
+	w    io.Writer
+	acc  uint64 // Bits that haven't been written yet, the oldest in the lowest bit
+	n    uint   // The number of valid bits in acc, always less than 64
+	word [8]byte
+	err  error // The first error from w
+}
+
+// NewBitWriter returns a BitWriter that writes to w
+// w: The writer to write the packed bytes to
+func NewBitWriter(w io.Writer) *BitWriter {
+	return &BitWriter{w: w}
+}
+
+// Reset drops any unwritten bits and makes the writer write to w, so it can be reused
+// w: The writer to write the packed bytes to
+func (bw *BitWriter) Reset(w io.Writer) {
+	*bw = BitWriter{w: w}
+}
+
+// WriteBits writes the low n bits of a value, least significant bit first
+// value: The bits to write, bits above the low n are ignored
+// n: The number of bits to write, at most 64
+func (bw *BitWriter) WriteBits(value uint64, n uint) {
+	if n < 64 {
+		value &= 1<<n - 1
+	}
+	bw.acc |= value << bw.n
+	if bw.n+n < 64 {
+		bw.n += n
+		return
+	}
+
+	// The register is full, write it and keep the bits that didn't fit
+	binary.LittleEndian.PutUint64(bw.word[:], bw.acc)
+	bw.write(bw.word[:])
+	used := 64 - bw.n
+	bw.acc = 0
+	if used < 64 {
+		bw.acc = value >> used
+	}
+	bw.n = bw.n + n - 64
+}
+
+// AlignToByte pads the current byte with zeros, so the next bit written starts a new byte
+func (bw *BitWriter) AlignToByte() {
+	bw.WriteBits(0, (8-bw.n%8)%8)
+}
+
+// Flush pads the last byte with zeros and writes every bit that's left. Returns the first error
+// from the underlying writer.
+func (bw *BitWriter) Flush() error {
+	bytes := (bw.n + 7) / 8
+	binary.LittleEndian.PutUint64(bw.word[:], bw.acc)
+	bw.write(bw.word[:bytes])
+	bw.acc, bw.n = 0, 0
+	return bw.err
+}
+
+// write writes bytes to the underlying writer, keeping the first error
+// p: The bytes to write
+func (bw *BitWriter) write(p []byte) {
+	if bw.err == nil && len(p) > 0 {
+		_, bw.err = bw.w.Write(p)
+	}
+}
@@ -0,0 +1,160 @@
+package common
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// The number of codes each benchmark writes or reads
+const BENCH_CODES = 1 << 18
+
+// A code to write, with the first bit of the code in the lowest bit
+type benchCode struct {
+	value  uint64
+	length uint
+}
+
+// benchCodes returns codes of 1 to 16 bits from a fixed seed, shorter codes are more common
+// like they are in a huffman coded block. Returns the codes and their total length in bits.
+func benchCodes() ([]benchCode, int) {
+	r := rand.New(rand.NewSource(1))
+	codes := make([]benchCode, BENCH_CODES)
+	bits := 0
+	for i := range codes {
+		length := uint(1 + r.ExpFloat64()*3)
+		if length > 16 {
+			length = 16
+		}
+		codes[i] = benchCode{r.Uint64() & (1<<length - 1), length}
+		bits += int(length)
+	}
+	return codes, bits
+}
+
+// bitStackCode returns a code as a BitStack, the way code tables held codes before BitWriter
+// code: The code
+func bitStackCode(code benchCode) BitStack {
+	stack := NewBitStack(MAX_CODE_SIZE)
+	for bit := uint(0); bit < code.length; bit++ {
+		stack.Push(byte(code.value >> bit & 1))
+	}
+	return stack
+}
+
+// writeWithBitWriter writes codes with a BitWriter
+// codes: The codes to write
+// out: The buffer to write to
+func writeWithBitWriter(codes []benchCode, out *bytes.Buffer) {
+	bw := NewBitWriter(out)
+	for _, c := range codes {
+		bw.WriteBits(c.value, c.length)
+	}
+	bw.Flush()
+}
+
+// writeWithBitStack writes codes the way blocks were written before BitWriter, appending each
+// code to a buffer stack bit by bit and writing the stack out whenever it fills up
+// stacks: The codes to write
+// out: The buffer to write to
+func writeWithBitStack(stacks []BitStack, out *bytes.Buffer) {
+	buffer := NewBitStack(MAX_BIT_BUFFER_SIZE)
+	for _, code := range stacks {
+		for next := 0; next < code.Size(); {
+			_, next = buffer.Append(code, next)
+			if uint64(buffer.Size()) == buffer.Vec().Capacity() {
+				out.Write(buffer.Vec().RawData())
+				buffer.Reset()
+			}
+		}
+	}
+	out.Write(buffer.Vec().RawData()[:(buffer.Size()+7)/8])
+}
+
+func TestBitWriterMatchesBitStack(t *testing.T) {
+	codes, _ := benchCodes()
+	stacks := make([]BitStack, len(codes))
+	for i, c := range codes {
+		stacks[i] = bitStackCode(c)
+	}
+	var fromWriter, fromStack bytes.Buffer
+	writeWithBitWriter(codes, &fromWriter)
+	writeWithBitStack(stacks, &fromStack)
+	if !bytes.Equal(fromWriter.Bytes(), fromStack.Bytes()) {
+		t.Fatal("BitWriter and BitStack wrote different bytes")
+	}
+
+	br := NewBitReader(bytes.NewReader(fromWriter.Bytes()))
+	for i, c := range codes {
+		value, err := br.ReadBits(c.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != c.value {
+			t.Fatalf("code %d read back as %b, expected %b", i, value, c.value)
+		}
+	}
+}
+
+func BenchmarkBitWriter(b *testing.B) {
+	codes, bits := benchCodes()
+	var out bytes.Buffer
+	b.SetBytes(int64(bits / 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		writeWithBitWriter(codes, &out)
+	}
+}
+
+func BenchmarkBitStackWrite(b *testing.B) {
+	codes, bits := benchCodes()
+	stacks := make([]BitStack, len(codes))
+	for i, c := range codes {
+		stacks[i] = bitStackCode(c)
+	}
+	var out bytes.Buffer
+	b.SetBytes(int64(bits / 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		writeWithBitStack(stacks, &out)
+	}
+}
+
+func BenchmarkBitReader(b *testing.B) {
+	codes, bits := benchCodes()
+	var packed bytes.Buffer
+	writeWithBitWriter(codes, &packed)
+	b.SetBytes(int64(bits / 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br := NewBitReader(bytes.NewReader(packed.Bytes()))
+		for _, c := range codes {
+			br.Peek(c.length)
+			br.Consume(c.length)
+		}
+	}
+}
+
+func BenchmarkBitVecRead(b *testing.B) {
+	// Blocks were decoded by reading the packed bytes into a BitVec one bit at a time
+	codes, bits := benchCodes()
+	var packed bytes.Buffer
+	writeWithBitWriter(codes, &packed)
+	b.SetBytes(int64(bits / 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vec := NewVectorFromData(packed.Bytes())
+		at := 0
+		for _, c := range codes {
+			value := uint64(0)
+			for bit := uint(0); bit < c.length; bit++ {
+				if vec.GetBit(at) {
+					value |= 1 << bit
+				}
+				at++
+			}
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package common
+
+// BWT blocks are Burrows-Wheeler transformed, move-to-front coded and have their runs of zeros
+// replaced with run digits before huffman coding, like bzip2. Every group of symbols is coded
+// with whichever of several code tables suits it best.
+
+const BWT_BLOCK_SIZE = 900 * 1024 // Uncompressed size of a block in a BWT stream.
+const BWT_GROUP_SIZE = 50         // The number of symbols coded with the same table.
+const MIN_BWT_TABLES = 2
+const MAX_BWT_TABLES = 6
+
+// Runs of zeros are written in bijective base 2, least significant digit first. The n-th digit of
+// a run adds 1<<n for RUN_A or 2<<n for RUN_B, and the move-to-front values 1 to 255 follow them.
+const RUN_A = 0
+const RUN_B = 1
+const BWT_ALPHABET_SIZE = ALPHABET_SIZE + 1 // The run digits and the non-zero move-to-front values.
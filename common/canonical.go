@@ -0,0 +1,71 @@
+package common
+
+// A symbol and the length of its code, lists of these describe codes over alphabets that are too
+// big to give every symbol a length
+type SymbolLength struct {
+	Symbol uint32
+	Length uint8
+}
+
+// CanonicalCodes assigns canonical huffman codes from a list of code lengths, shorter codes come
+// first and codes of the same length are given out in symbol order. Symbols with a length of 0
+// get no code. The first bit of a code of length n is bit n-1 of its value.
+// lengths: The code length of every symbol in the alphabet
+func CanonicalCodes(lengths []uint8) []uint64 {
+	sparse := SparseCodeLengths(lengths)
+	codes := make([]uint64, len(lengths))
+	for i, code := range CanonicalSymbolCodes(sparse) {
+		codes[sparse[i].Symbol] = code
+	}
+	return codes
+}
+
+// CanonicalSymbolCodes is like CanonicalCodes but only takes the symbols that have a code, and
+// returns their codes in the same order
+// lengths: The symbols that have a code and their code lengths, in symbol order
+func CanonicalSymbolCodes(lengths []SymbolLength) []uint64 {
+	// Count the number of codes of each length
+	var lengthCounts [256]uint64
+	for _, l := range lengths {
+		lengthCounts[l.Length]++
+	}
+
+	// Find the first code of each length
+	var nextCode [256]uint64
+	code := uint64(0)
+	for length := 1; length < len(nextCode); length++ {
+		code = (code + lengthCounts[length-1]) << 1
+		nextCode[length] = code
+	}
+
+	// Hand out codes in symbol order
+	codes := make([]uint64, len(lengths))
+	for i, l := range lengths {
+		codes[i] = nextCode[l.Length]
+		nextCode[l.Length]++
+	}
+	return codes
+}
+
+// SparseCodeLengths lists the symbols that have a code, in symbol order
+// lengths: The code length of every symbol in the alphabet
+func SparseCodeLengths(lengths []uint8) []SymbolLength {
+	sparse := make([]SymbolLength, 0, len(lengths))
+	for symbol, length := range lengths {
+		if length != 0 {
+			sparse = append(sparse, SymbolLength{uint32(symbol), length})
+		}
+	}
+	return sparse
+}
+
+// DenseCodeLengths gives every symbol in an alphabet a code length, 0 for symbols without a code
+// lengths: The symbols that have a code and their code lengths, every symbol must be in the alphabet
+// alphabetSize: The number of symbols in the alphabet
+func DenseCodeLengths(lengths []SymbolLength, alphabetSize int) []uint8 {
+	dense := make([]uint8, alphabetSize)
+	for _, l := range lengths {
+		dense[l.Symbol] = l.Length
+	}
+	return dense
+}
@@ -0,0 +1,58 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+)
+
+// The checksums a stream can carry
+const CHECKSUM_NONE = 0 // No checksums, for data that's already protected some other way
+const CHECKSUM_CRC32C = 1 // CRC-32C of the data, every block header and tree dump, and the block index
+
+// Streams are checksummed with CRC-32C
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// NewChecksum returns a hash that computes the checksum of the original data in a stream
+func NewChecksum() hash.Hash32 {
+	return crc32.New(checksumTable)
+}
+
+// BlockChecksum returns the checksum covering a block header and its tree dump
+// header: The block header
+// version: The version of the stream, which decides the fields of the header
+// treeDump: The tree dump that follows the header
+func BlockChecksum(header *BlockHeader, version uint8, treeDump []byte) uint32 {
+	buf := new(bytes.Buffer)
+	for _, field := range header.Fields(version) {
+		binary.Write(buf, StreamByteOrder, field)
+	}
+	buf.Write(treeDump)
+	return crc32.Checksum(buf.Bytes(), checksumTable)
+}
+
+// IndexChecksum returns the checksum covering the entries of a block index
+// entries: The index entries
+func IndexChecksum(entries []IndexEntry) uint32 {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, StreamByteOrder, entries)
+	return crc32.Checksum(buf.Bytes(), checksumTable)
+}
+
+// DirectoryChecksum returns the checksum covering the central directory of an archive
+// directory: The bytes of the directory
+func DirectoryChecksum(directory []byte) uint32 {
+	return crc32.Checksum(directory, checksumTable)
+}
+
+// SymbolData returns the bytes that the checksum of a stream of symbols covers, each symbol is a
+// little-endian uint32
+// symbols: The symbols
+func SymbolData(symbols []uint32) []byte {
+	data := make([]byte, 4*len(symbols))
+	for i, symbol := range symbols {
+		StreamByteOrder.PutUint32(data[4*i:], symbol)
+	}
+	return data
+}
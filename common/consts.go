@@ -2,8 +2,17 @@ package common
 
 const MAX_IO_BLOCK_SIZE = 4096
 const MAGIC_NUMBER = 0xDEADBEEF
-const ALPHABET_SIZE = 256
-const MAX_CODE_SIZE = ALPHABET_SIZE / 8 // Bytes for a maximum, 256-bit code.
+const STREAM_MAGIC_NUMBER = 0xC0DEBEEF // Magic number for block based streams.
+const FORMAT_VERSION = 3 // Version of the stream layout written by this build.
+const INDEX_MAGIC_NUMBER = 0xB10CBEEF // Magic number at the very end of a stream's block index.
+const STREAM_BLOCK_SIZE = 64 * 1024 // Uncompressed size of a block in a stream, each block can get its own tree.
+const ALPHABET_SIZE = 256 // Symbols in the byte alphabet, streams of other symbols record their own alphabet size.
+const SYMBOL_BLOCK_SIZE = 8 * 1024 // Symbols in a block of a stream that isn't made of bytes.
+const MIN_BLOCK_SIZE = 4 * 1024 // Shortest block a writer can be set up with, block headers cost more than they save below it.
+const MAX_TREE_REUSE = 16 // Most blocks in a row that can follow the last block that stored a tree, so decoders never look far back for it.
+const MAX_BLOCK_SIZE = 16 * 1024 * 1024 // Largest block a decoder accepts, so a corrupt block header can't exhaust memory.
+const MAX_CODE_SIZE = ALPHABET_SIZE / 8 // Bits in a HuffCode, the longest code that can be stored.
+const MIN_CODE_LENGTH_LIMIT = 8 // The shortest code length limit that still fits every symbol in the alphabet.
 const MAX_TREE_SIZE = 3 * ALPHABET_SIZE - 1 // Maximum Huffman tree dump size.
 const MAX_BIT_BUFFER_SIZE = MAX_IO_BLOCK_SIZE * 8 // Maximum size of a bit buffer.
 const LEAF_DUMP_CHAR = 'L'
@@ -0,0 +1,29 @@
+package common
+
+import (
+	"context"
+	"io"
+)
+
+// ContextReader is an io.Reader that fails with its context's error once the context is done.
+// The context is checked before every read, a read that has already started isn't interrupted.
+type ContextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// NewContextReader returns a ContextReader that reads from r until ctx is done
+// ctx: The context that stops the reads
+// r: The reader to read from
+func NewContextReader(ctx context.Context, r io.Reader) *ContextReader {
+	return &ContextReader{ctx: ctx, r: r}
+}
+
+// Read reads from the underlying reader, unless the context is done
+// p: The buffer to read into
+func (c *ContextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
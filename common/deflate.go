@@ -0,0 +1,86 @@
+package common
+
+// DEFLATE (RFC 1951) streams in gzip (RFC 1952) members share the LZ77 alphabets, and code them
+// with either fixed or per-block code lengths
+
+const GZIP_ID1 = 0x1f         // The first byte of a gzip member.
+const GZIP_ID2 = 0x8b         // The second byte of a gzip member.
+const GZIP_METHOD_DEFLATE = 8 // The only compression method gzip defines.
+const GZIP_OS_UNKNOWN = 255
+
+// Flags in a gzip member header
+const GZIP_FLAG_TEXT = 1 << 0
+const GZIP_FLAG_HCRC = 1 << 1
+const GZIP_FLAG_EXTRA = 1 << 2
+const GZIP_FLAG_NAME = 1 << 3
+const GZIP_FLAG_COMMENT = 1 << 4
+const GZIP_KNOWN_FLAGS = GZIP_FLAG_TEXT | GZIP_FLAG_HCRC | GZIP_FLAG_EXTRA | GZIP_FLAG_NAME | GZIP_FLAG_COMMENT
+
+// The header at the start of a gzip member, optional fields follow it when their flags are set
+type GzipHeader struct {
+	ID1               uint8
+	ID2               uint8
+	CompressionMethod uint8
+	Flags             uint8
+	ModTime           uint32 // Unix time the original file was modified, 0 if unknown
+	ExtraFlags        uint8
+	OS                uint8
+}
+
+// The trailer at the end of a gzip member
+type GzipTrailer struct {
+	DataChecksum uint32 // The CRC-32 (IEEE) of the original data
+	Size         uint32 // The size of the original data modulo 2^32
+}
+
+// CreateGzipHeader creates a gzip member header with no optional fields
+func CreateGzipHeader() *GzipHeader {
+	return &GzipHeader{GZIP_ID1, GZIP_ID2, GZIP_METHOD_DEFLATE, 0, 0, 0, GZIP_OS_UNKNOWN}
+}
+
+// DEFLATE block types, stored in the 2 bits after a block's final flag
+const DEFLATE_STORED = 0
+const DEFLATE_FIXED = 1
+const DEFLATE_DYNAMIC = 2
+
+const MAX_STORED_BLOCK_SIZE = 65535   // The most bytes a stored block can hold.
+const MAX_DEFLATE_CODE_LENGTH = 15    // The longest literal/length or distance code.
+const CODE_LENGTH_ALPHABET_SIZE = 19  // Code lengths 0 to 15 and the three run symbols.
+const MAX_CODE_LENGTH_CODE_LENGTH = 7 // The longest code for the code length alphabet.
+
+// Symbols of the code length alphabet that stand for runs of code lengths
+const REPEAT_PREVIOUS = 16  // Repeats the previous length 3-6 times, 2 extra bits.
+const REPEAT_ZERO = 17      // Repeats a zero length 3-10 times, 3 extra bits.
+const REPEAT_ZERO_LONG = 18 // Repeats a zero length 11-138 times, 7 extra bits.
+
+// The order the code lengths of the code length alphabet are stored in
+var CodeLengthOrder = [CODE_LENGTH_ALPHABET_SIZE]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+// FixedLiteralLengths returns the code lengths of the fixed literal/length code, it has two more
+// symbols than the alphabet that are never used
+func FixedLiteralLengths() []uint8 {
+	lengths := make([]uint8, LITERAL_LENGTH_ALPHABET_SIZE+2)
+	for symbol := range lengths {
+		switch {
+		case symbol < 144:
+			lengths[symbol] = 8
+		case symbol < 256:
+			lengths[symbol] = 9
+		case symbol < 280:
+			lengths[symbol] = 7
+		default:
+			lengths[symbol] = 8
+		}
+	}
+	return lengths
+}
+
+// FixedDistanceLengths returns the code lengths of the fixed distance code, it has two more
+// symbols than the alphabet that are never used
+func FixedDistanceLengths() []uint8 {
+	lengths := make([]uint8, DISTANCE_ALPHABET_SIZE+2)
+	for symbol := range lengths {
+		lengths[symbol] = 5
+	}
+	return lengths
+}
@@ -1,5 +1,21 @@
 package common
 
+import "encoding/binary"
+
+// Stream and block headers are always little-endian so files are portable between machines
+var StreamByteOrder = binary.LittleEndian
+
+// Feature flags in the stream header, decoders must reject any flag they don't know
+const FLAG_CHECKSUM = 1 << 0 // Block headers and the original data are checksummed
+const FLAG_CANONICAL_CODES = 1 << 1 // Blocks store the code lengths of a canonical code instead of a tree dump
+const FLAG_BLOCK_INDEX = 1 << 2 // The stream ends with an index of its blocks
+const FLAG_ADAPTIVE = 1 << 3 // Blocks are coded with an adaptive tree that starts empty in every block
+const FLAG_LZ77 = 1 << 4 // Blocks are LZ77 coded and store code lengths for a literal/length and a distance alphabet
+const FLAG_ALPHABET = 1 << 5 // The stream codes symbols from an alphabet other than bytes, and stores the alphabet size
+const FLAG_BWT = 1 << 6 // Blocks are Burrows-Wheeler transformed and store several code tables
+const FLAG_CONTEXT = 1 << 7 // Blocks code each byte with a code table picked by the byte before it
+const KNOWN_FLAGS = FLAG_CHECKSUM | FLAG_CANONICAL_CODES | FLAG_BLOCK_INDEX | FLAG_ADAPTIVE | FLAG_LZ77 | FLAG_ALPHABET | FLAG_BWT | FLAG_CONTEXT
+
 // The header for compressed files
 type HuffHeader struct {
 	MagicNumber      uint32
@@ -10,4 +26,91 @@ type HuffHeader struct {
 // CreateHeader creates a header
 func CreateHeader(treeSize uint16, originalFileSize uint64) *HuffHeader {
 	return &HuffHeader{MAGIC_NUMBER, uint32(treeSize), int64(originalFileSize)}
-}
\ No newline at end of file
+}
+
+// The header at the start of a block based stream
+type StreamHeader struct {
+	MagicNumber   uint32
+	Version       uint8  // The layout version of the stream
+	Flags         uint8  // Optional features used by the stream
+	MaxCodeLength uint8  // The longest code any block may use, added in version 2 (0 for older streams)
+	AlphabetSize  uint32 // The number of symbols in the alphabet, only stored with FLAG_ALPHABET (ALPHABET_SIZE otherwise)
+}
+
+// Fields returns pointers to the fields of the header in the order they're stored. Fields that
+// were added by later versions, or that are optional, are only included when the header has them.
+func (h *StreamHeader) Fields() []interface{} {
+	fields := []interface{}{&h.MagicNumber, &h.Version, &h.Flags}
+	if h.Version >= 2 {
+		fields = append(fields, &h.MaxCodeLength)
+	}
+	if h.Flags&FLAG_ALPHABET != 0 {
+		fields = append(fields, &h.AlphabetSize)
+	}
+	return fields
+}
+
+// How a block is coded, stored in block headers from version 3 on
+const BLOCK_CODED = 0 // The block stores its own tree dump or code lengths, every block before version 3 is coded
+const BLOCK_REUSED_TREE = 1 // The block has no dump and is coded with the dump of the last block that stored one
+const BLOCK_STORED = 2 // The block has no dump and its payload is the original data
+
+// The header written before every block in a stream
+type BlockHeader struct {
+	UncompressedSize uint32 // The size of the block once decoded (in symbols with FLAG_ALPHABET), 0 marks the end of the stream
+	TreeSize         uint16 // The size of the tree dump or code length dump that follows the header
+	PayloadSize      uint32 // The number of bytes of huffman coded data that follow the tree dump and its checksum
+	BlockType        uint8  // How the block is coded, added in version 3 (BLOCK_CODED for older streams)
+}
+
+// Fields returns pointers to the fields of the header in the order they're stored in a stream of
+// the given version
+// version: The version of the stream
+func (h *BlockHeader) Fields(version uint8) []interface{} {
+	fields := []interface{}{&h.UncompressedSize, &h.TreeSize, &h.PayloadSize}
+	if version >= 3 {
+		fields = append(fields, &h.BlockType)
+	}
+	return fields
+}
+
+// The trailer written after the last block of a stream that has FLAG_CHECKSUM set
+type StreamTrailer struct {
+	DataChecksum uint32 // The checksum of all of the original data
+}
+
+// An entry in the block index, there is one for every block that holds data
+type IndexEntry struct {
+	BitOffset        uint64 // Where the block header starts, in bits from the start of the stream
+	UncompressedSize uint32 // The size of the block once decoded
+}
+
+// The footer that ends a stream with FLAG_BLOCK_INDEX set, the index entries come right before it
+type IndexFooter struct {
+	BlockCount    uint32 // The number of index entries
+	IndexChecksum uint32 // The checksum of the index entries
+	MagicNumber   uint32
+}
+
+// CreateStreamHeader creates a stream header
+// flags: The features used by the stream
+// maxCodeLength: The longest code any block may use
+// alphabetSize: The number of symbols in the alphabet
+func CreateStreamHeader(flags uint8, maxCodeLength uint8, alphabetSize uint32) *StreamHeader {
+	return &StreamHeader{STREAM_MAGIC_NUMBER, FORMAT_VERSION, flags, maxCodeLength, alphabetSize}
+}
+
+// CreateBlockHeader creates the header of a coded block
+// uncompressedSize: The size of the block once decoded
+// treeSize: The size of the block's tree dump
+// payloadSize: The size of the block's huffman coded data
+func CreateBlockHeader(uncompressedSize uint32, treeSize uint16, payloadSize uint32) *BlockHeader {
+	return &BlockHeader{uncompressedSize, treeSize, payloadSize, BLOCK_CODED}
+}
+
+
+// CreateIndexFooter creates an index footer
+// entries: The entries of the block index
+func CreateIndexFooter(entries []IndexEntry) *IndexFooter {
+	return &IndexFooter{uint32(len(entries)), IndexChecksum(entries), INDEX_MAGIC_NUMBER}
+}
@@ -17,7 +17,7 @@ type HuffNode interface {
 
 // Simple Struct to hold the data for a node
 type NodeData struct {
-	Symbol byte
+	Symbol uint32
 	Weight int
 }
 
@@ -76,7 +76,7 @@ func (n *node) Join(right HuffNode) HuffNode {
 // NewNode creates a new node
 // symbol: The symbol to be stored in the node
 // weight: The weight of the node
-func NewNode(symbol byte, weight int) HuffNode {
+func NewNode(symbol uint32, weight int) HuffNode {
 	return &node{
 		data: NodeData{
 			Symbol: symbol,
@@ -0,0 +1,61 @@
+package common
+
+// LZ77 blocks code literals and match lengths with one alphabet and match distances with another,
+// laid out the same way as DEFLATE's
+
+const END_OF_BLOCK = 256                 // The literal/length symbol that ends an LZ77 block.
+const FIRST_LENGTH_SYMBOL = 257          // The literal/length symbol for the shortest match.
+const LITERAL_LENGTH_ALPHABET_SIZE = 286 // Literals, the end of block symbol and the match length symbols.
+const DISTANCE_ALPHABET_SIZE = 30        // Match distance symbols.
+const MIN_MATCH_LENGTH = 3
+const MAX_MATCH_LENGTH = 258
+const MIN_LZ77_WINDOW = 256       // The smallest window the match finder can be configured with.
+const MAX_LZ77_WINDOW = 32 * 1024 // The furthest back a match can be, the largest distance the alphabet can code.
+
+// The shortest match length of each length symbol, and the number of extra bits that follow it
+var LengthBase = [LITERAL_LENGTH_ALPHABET_SIZE - FIRST_LENGTH_SYMBOL]uint16{
+	3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31,
+	35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258,
+}
+var LengthExtraBits = [LITERAL_LENGTH_ALPHABET_SIZE - FIRST_LENGTH_SYMBOL]uint8{
+	0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2,
+	3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0,
+}
+
+// The shortest distance of each distance symbol, and the number of extra bits that follow it
+var DistanceBase = [DISTANCE_ALPHABET_SIZE]uint16{
+	1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193,
+	257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577,
+}
+var DistanceExtraBits = [DISTANCE_ALPHABET_SIZE]uint8{
+	0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6,
+	7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13,
+}
+
+// LengthSymbol returns the literal/length symbol for a match length
+// length: The match length, from MIN_MATCH_LENGTH to MAX_MATCH_LENGTH
+func LengthSymbol(length int) uint32 {
+	return FIRST_LENGTH_SYMBOL + uint32(searchBase(LengthBase[:], length))
+}
+
+// DistanceSymbol returns the distance symbol for a match distance
+// distance: The match distance, from 1 to MAX_LZ77_WINDOW
+func DistanceSymbol(distance int) uint32 {
+	return uint32(searchBase(DistanceBase[:], distance))
+}
+
+// searchBase returns the index of the last base that's no bigger than value
+// bases: The bases in increasing order
+// value: The value to look up
+func searchBase(bases []uint16, value int) int {
+	lo, hi := 0, len(bases)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if int(bases[mid]) <= value {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
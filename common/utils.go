@@ -2,6 +2,7 @@ package common
 
 import (
 	"encoding/binary"
+	"io"
 	"os"
 	"unsafe"
 )
@@ -18,10 +19,34 @@ func Endianess() binary.ByteOrder {
 
 // GetFileSize returns the size of a file in bytes
 // file: the  pointer to the file
-func GetFileSize(file *os.File) int64 {
+func GetFileSize(file *os.File) (int64, error) {
 	fi, err := file.Stat()
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-	return fi.Size()
-}
\ No newline at end of file
+	return fi.Size(), nil
+}
+
+// A reader that counts the bytes read through it
+type CountingReader struct {
+	R io.Reader
+	N int64 // The number of bytes read so far
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.R.Read(p)
+	c.N += int64(n)
+	return n, err
+}
+
+// A writer that counts the bytes written through it
+type CountingWriter struct {
+	W io.Writer
+	N int64 // The number of bytes written so far
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	n, err := c.W.Write(p)
+	c.N += int64(n)
+	return n, err
+}
@@ -0,0 +1,23 @@
+package compress
+
+import (
+	"bytes"
+
+	"io.whypeople/huffman/common"
+)
+
+// encodeAdaptive codes a block with an adaptive Huffman tree and returns the packed bits
+// data: The data to be encoded
+func encodeAdaptive(data []byte) []byte {
+	out := new(bytes.Buffer)
+	bw := common.NewBitWriter(out)
+	tree := common.NewAdaptiveTree()
+
+	for _, b := range data {
+		tree.Code(b, bw)
+		tree.Update(b)
+	}
+
+	bw.Flush()
+	return out.Bytes()
+}
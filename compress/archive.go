@@ -0,0 +1,215 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"io.whypeople/huffman/common"
+)
+
+// ArchiveWriter packs files, directories and symlinks into an archive. The data of every file is
+// compressed into its own stream, and Close writes the central directory that describes them.
+type ArchiveWriter struct {
+	w           io.Writer
+	newWriter   func(io.Writer) (io.WriteCloser, error)
+	written     uint64       // The number of bytes written to w
+	directory   bytes.Buffer // The central directory so far
+	entryCount  uint32
+	wroteHeader bool
+	closed      bool
+	err         error
+}
+
+// NewArchiveWriter returns a new ArchiveWriter, the caller must Close it to write the central directory
+// w: The writer to write the archive to
+// newWriter: Creates the writer that compresses each file, nil for NewWriter
+func NewArchiveWriter(w io.Writer, newWriter func(io.Writer) (io.WriteCloser, error)) *ArchiveWriter {
+	if newWriter == nil {
+		newWriter = func(w io.Writer) (io.WriteCloser, error) { return NewWriter(w), nil }
+	}
+	return &ArchiveWriter{w: w, newWriter: newWriter}
+}
+
+// Add adds an entry to the archive, the data of a file is compressed and its size is taken from
+// the data rather than the entry
+// entry: The entry to add
+// data: The data of a file, nil for other entries
+func (a *ArchiveWriter) Add(entry common.ArchiveEntry, data io.Reader) error {
+	if a.err != nil {
+		return a.err
+	}
+	if a.closed {
+		return errors.New("add to closed archive")
+	}
+	if err := common.CheckArchivePath(entry.Path); err != nil {
+		return err
+	}
+	if len(entry.LinkTarget) > common.MAX_ARCHIVE_PATH {
+		return errors.New("symlink target is too long")
+	}
+	a.writeHeader()
+
+	dirEntry := common.DirectoryEntry{
+		Type:       entry.Type,
+		Mode:       entry.Mode,
+		ModTime:    entry.ModTime,
+		DataOffset: a.written,
+		PathLength: uint16(len(entry.Path)),
+		LinkLength: uint16(len(entry.LinkTarget)),
+	}
+	if entry.Type == common.ENTRY_FILE {
+		writer, err := a.newWriter(archiveDataWriter{a})
+		if err != nil {
+			return err
+		}
+		size, err := io.Copy(writer, data)
+		if err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		dirEntry.Size = uint64(size)
+		dirEntry.DataSize = a.written - dirEntry.DataOffset
+	}
+
+	binary.Write(&a.directory, common.StreamByteOrder, &dirEntry)
+	a.directory.WriteString(entry.Path)
+	a.directory.WriteString(entry.LinkTarget)
+	a.entryCount++
+	return a.err
+}
+
+// AddPath adds a file, symlink or whole directory tree to the archive, symlinks are stored rather
+// than followed. Entries are named after the last element of root, unless root is a directory
+// like "." that has no name of its own, in which case its contents are added at the top level.
+// Returns the number of bytes of file data added.
+// root: The path of the file or directory
+func (a *ArchiveWriter) AddPath(root string) (int64, error) {
+	root = filepath.Clean(root)
+	prefix := filepath.Base(root)
+	if prefix == "." || prefix == ".." || prefix == string(filepath.Separator) {
+		prefix = ""
+	}
+
+	// An archive written inside the tree it's archiving mustn't archive itself
+	var self fs.FileInfo
+	if file, ok := a.w.(*os.File); ok {
+		self, _ = file.Stat()
+	}
+
+	total := int64(0)
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		entryPath := path.Join(prefix, filepath.ToSlash(rel))
+		if entryPath == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if self != nil && os.SameFile(info, self) {
+			return nil
+		}
+
+		entry := common.ArchiveEntry{
+			Path:    entryPath,
+			Mode:    uint32(info.Mode().Perm()),
+			ModTime: info.ModTime().UnixNano(),
+		}
+		switch {
+		case info.Mode().IsRegular():
+			file, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			entry.Type = common.ENTRY_FILE
+			if err := a.Add(entry, file); err != nil {
+				return err
+			}
+			total += info.Size()
+			return nil
+		case info.IsDir():
+			entry.Type = common.ENTRY_DIRECTORY
+		case info.Mode()&fs.ModeSymlink != 0:
+			entry.Type = common.ENTRY_SYMLINK
+			if entry.LinkTarget, err = os.Readlink(name); err != nil {
+				return err
+			}
+		default:
+			// Devices, sockets and pipes can't be archived
+			return nil
+		}
+		return a.Add(entry, nil)
+	})
+	return total, err
+}
+
+// Close writes the central directory and ends the archive, it does not close the underlying writer
+func (a *ArchiveWriter) Close() error {
+	if a.closed {
+		return a.err
+	}
+	a.closed = true
+	a.writeHeader()
+
+	footer := common.CreateDirectoryFooter(a.entryCount, a.written, a.directory.Bytes())
+	a.write(a.directory.Bytes())
+	a.write(footer)
+	return a.err
+}
+
+// Written returns the number of bytes of the archive written so far
+func (a *ArchiveWriter) Written() int64 {
+	return int64(a.written)
+}
+
+// writeHeader writes the archive header if it hasn't been written yet
+func (a *ArchiveWriter) writeHeader() {
+	if !a.wroteHeader {
+		a.wroteHeader = true
+		a.write(common.CreateArchiveHeader())
+	}
+}
+
+// write writes raw bytes or fixed size data to the underlying writer, keeping the first error
+// data: The data to write
+func (a *ArchiveWriter) write(data interface{}) {
+	if a.err != nil {
+		return
+	}
+	if raw, ok := data.([]byte); ok {
+		_, a.err = a.w.Write(raw)
+		a.written += uint64(len(raw))
+	} else {
+		a.err = binary.Write(a.w, common.StreamByteOrder, data)
+		a.written += uint64(binary.Size(data))
+	}
+}
+
+// The writer a file's compressed stream is written through, so the archive can count its size
+type archiveDataWriter struct {
+	a *ArchiveWriter
+}
+
+func (d archiveDataWriter) Write(p []byte) (int, error) {
+	d.a.write(p)
+	if d.a.err != nil {
+		return 0, d.a.err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,191 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"io.whypeople/huffman/common"
+	"io.whypeople/huffman/decompress"
+)
+
+// archiveBytes returns the archive an ArchiveWriter writes
+// t: The test
+// add: Adds the entries to the archive
+func archiveBytes(t *testing.T, add func(a *ArchiveWriter) error) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	a := NewArchiveWriter(&archive, nil)
+	if err := add(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Written() != int64(archive.Len()) {
+		t.Fatalf("Written returned %d, the archive is %d bytes", a.Written(), archive.Len())
+	}
+	return archive.Bytes()
+}
+
+// readArchive opens an archive written by archiveBytes
+// t: The test
+// archive: The archive
+func readArchive(t *testing.T, archive []byte) *decompress.ArchiveReader {
+	t.Helper()
+	a, err := decompress.NewArchiveReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestArchiveAddOpen(t *testing.T) {
+	files := map[string][]byte{
+		"empty":      {},
+		"text":       []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 100)),
+		"dir/skewed": randomBytes(3*common.STREAM_BLOCK_SIZE+17, 1),
+		"dir/one":    {'x'},
+	}
+	entries := []common.ArchiveEntry{
+		{Path: "empty", Type: common.ENTRY_FILE, Mode: 0600, ModTime: 1},
+		{Path: "text", Type: common.ENTRY_FILE, Mode: 0644, ModTime: 2},
+		{Path: "dir", Type: common.ENTRY_DIRECTORY, Mode: 0755, ModTime: 3},
+		{Path: "dir/skewed", Type: common.ENTRY_FILE, Mode: 0640, ModTime: 4},
+		{Path: "dir/one", Type: common.ENTRY_FILE, Mode: 0400, ModTime: 5},
+		{Path: "link", Type: common.ENTRY_SYMLINK, Mode: 0777, ModTime: 6, LinkTarget: "dir/one"},
+	}
+	archive := archiveBytes(t, func(a *ArchiveWriter) error {
+		for _, entry := range entries {
+			var data io.Reader
+			if entry.Type == common.ENTRY_FILE {
+				data = bytes.NewReader(files[entry.Path])
+			}
+			if err := a.Add(entry, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	a := readArchive(t, archive)
+	listed := a.Entries()
+	if len(listed) != len(entries) {
+		t.Fatalf("listed %d entries, added %d", len(listed), len(entries))
+	}
+	for i, entry := range entries {
+		entry.Size = uint64(len(files[entry.Path]))
+		if listed[i] != entry {
+			t.Fatalf("entry %d listed as %+v, added %+v", i, listed[i], entry)
+		}
+		if entry.Type != common.ENTRY_FILE {
+			if _, err := a.Open(i); err == nil {
+				t.Fatalf("opened %s, which isn't a file", entry.Path)
+			}
+			continue
+		}
+		reader, err := a.Open(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, files[entry.Path]) {
+			t.Fatalf("%s decoded to different data", entry.Path)
+		}
+	}
+	if err := a.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveAddPathExtract(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "tree")
+	files := map[string][]byte{
+		"text":         []byte(strings.Repeat("abcabcabd", 1000)),
+		"empty":        {},
+		"sub/skewed":   randomBytes(2*common.STREAM_BLOCK_SIZE+3, 2),
+		"sub/deep/one": {0},
+	}
+	for name, data := range files {
+		file := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, data, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("sub/deep/one", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Unix(1600000000, 0)
+	if err := os.Chtimes(filepath.Join(src, "sub"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	var added int64
+	archive := archiveBytes(t, func(a *ArchiveWriter) (err error) {
+		added, err = a.AddPath(src)
+		return err
+	})
+	total := 0
+	for _, data := range files {
+		total += len(data)
+	}
+	if added != int64(total) {
+		t.Fatalf("AddPath added %d bytes of file data, expected %d", added, total)
+	}
+
+	// Entries are named after the directory that was added
+	a := readArchive(t, archive)
+	types := make(map[string]uint8)
+	for _, entry := range a.Entries() {
+		types[entry.Path] = entry.Type
+	}
+	want := map[string]uint8{
+		"tree": common.ENTRY_DIRECTORY, "tree/sub": common.ENTRY_DIRECTORY, "tree/sub/deep": common.ENTRY_DIRECTORY,
+		"tree/link": common.ENTRY_SYMLINK,
+	}
+	for name := range files {
+		want["tree/"+name] = common.ENTRY_FILE
+	}
+	if len(types) != len(want) {
+		t.Fatalf("listed %v, expected %v", types, want)
+	}
+	for name, entryType := range want {
+		if got, ok := types[name]; !ok || got != entryType {
+			t.Fatalf("listed %v, expected %v", types, want)
+		}
+	}
+
+	dst := t.TempDir()
+	if err := a.Extract(dst); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		file := filepath.Join(dst, "tree", filepath.FromSlash(name))
+		extracted, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(extracted, data) {
+			t.Fatalf("%s was extracted with different data", name)
+		}
+		if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0640 {
+			t.Fatalf("%s was extracted with mode %v, %v", name, info.Mode(), err)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "tree", "link")); err != nil || target != "sub/deep/one" {
+		t.Fatalf("link points to %q, %v", target, err)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "tree", "sub")); err != nil || !info.ModTime().Equal(modTime) {
+		t.Fatalf("sub was extracted with modification time %v, %v", info.ModTime(), err)
+	}
+}
@@ -0,0 +1,293 @@
+package compress
+
+import (
+	"bytes"
+
+	"io.whypeople/huffman/common"
+)
+
+const BWT_TABLE_ITERATIONS = 4 // The number of times the code tables are refined to fit their groups.
+
+// compressBWTBlock Burrows-Wheeler transforms a block, move-to-front and run-length codes it, and
+// huffman codes the result with several code tables. Returns the block's dump and payload.
+// The dump holds the row of the original data in the sorted rotations, the number of coded
+// symbols, the number of tables and a code length dump for each table. The payload holds the
+// table of every group of symbols followed by the coded symbols.
+// block: The uncompressed data, must not be empty
+// maxCodeLength: The longest code the block may use
+func compressBWTBlock(block []byte, maxCodeLength int) ([]byte, []byte, error) {
+	last, origin := burrowsWheeler(block)
+	symbols := runLengthSymbols(moveToFront(last))
+	lengths, selectors, err := chooseTables(symbols, maxCodeLength)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dump := make([]byte, 9)
+	common.StreamByteOrder.PutUint32(dump[0:], uint32(origin))
+	common.StreamByteOrder.PutUint32(dump[4:], uint32(len(symbols)))
+	dump[8] = uint8(len(lengths))
+	codeTables := make([]HuffCodeTable, len(lengths))
+	for i := range lengths {
+		dump = append(dump, CreateCodeLengthDump(lengths[i])...)
+		codeTables[i] = CodeLengthsToCodeTable(lengths[i])
+	}
+
+	out := new(bytes.Buffer)
+	bw := common.NewBitWriter(out)
+
+	// Selectors are move-to-front coded, so a table that's used again is written as a single bit
+	var order [common.MAX_BWT_TABLES]uint8
+	for i := range order {
+		order[i] = uint8(i)
+	}
+	for _, selector := range selectors {
+		j := 0
+		for order[j] != selector {
+			j++
+		}
+		copy(order[1:j+1], order[:j])
+		order[0] = selector
+		bw.WriteBits(1<<uint(j)-1, uint(j+1))
+	}
+
+	for i, symbol := range symbols {
+		writeCode(bw, codeTables[selectors[i/common.BWT_GROUP_SIZE]][uint32(symbol)])
+	}
+	bw.Flush()
+	return dump, out.Bytes(), nil
+}
+
+// burrowsWheeler returns the last byte of each rotation of data once the rotations are sorted,
+// and the row of the rotation that starts at the beginning of data
+// data: The data to be transformed, must not be empty
+func burrowsWheeler(data []byte) ([]byte, int) {
+	rotations := sortRotations(data)
+	last := make([]byte, len(data))
+	origin := 0
+	for row, start := range rotations {
+		if start == 0 {
+			origin = row
+			start = int32(len(data))
+		}
+		last[row] = data[start-1]
+	}
+	return last, origin
+}
+
+// sortRotations builds the suffix array of data's rotations by prefix doubling. Once rotations
+// are sorted by their first k bytes, sorting them by the rank of their first k bytes and then of
+// the k bytes after sorts them by their first 2k bytes. Each step is a counting sort, so it takes
+// O(n log n) time for n bytes.
+// data: The data whose rotations are sorted, must not be empty
+func sortRotations(data []byte) []int32 {
+	n := len(data)
+	rotations := make([]int32, n)
+	rank := make([]int32, n)
+
+	// Sort by the first byte
+	var counts [common.ALPHABET_SIZE]int
+	for _, b := range data {
+		counts[b]++
+	}
+	for i := 1; i < len(counts); i++ {
+		counts[i] += counts[i-1]
+	}
+	for i := n - 1; i >= 0; i-- {
+		counts[data[i]]--
+		rotations[counts[data[i]]] = int32(i)
+	}
+	classes := int32(1)
+	for i := 1; i < n; i++ {
+		if data[rotations[i]] != data[rotations[i-1]] {
+			classes++
+		}
+		rank[rotations[i]] = classes - 1
+	}
+
+	shifted := make([]int32, n)
+	newRank := make([]int32, n)
+	classCounts := make([]int32, n)
+	for k := 1; k < n && int(classes) < n; k <<= 1 {
+		// Rotations starting k bytes earlier are already in order of their second half
+		for i, start := range rotations {
+			shifted[i] = int32((int(start) - k + n) % n)
+		}
+
+		// A stable sort by the first half then puts them in order of both
+		for i := int32(0); i < classes; i++ {
+			classCounts[i] = 0
+		}
+		for _, start := range shifted {
+			classCounts[rank[start]]++
+		}
+		for i := int32(1); i < classes; i++ {
+			classCounts[i] += classCounts[i-1]
+		}
+		for i := n - 1; i >= 0; i-- {
+			start := shifted[i]
+			classCounts[rank[start]]--
+			rotations[classCounts[rank[start]]] = start
+		}
+
+		// Rotations whose halves both rank the same are still equal
+		classes = 1
+		newRank[rotations[0]] = 0
+		for i := 1; i < n; i++ {
+			cur, prev := int(rotations[i]), int(rotations[i-1])
+			if rank[cur] != rank[prev] || rank[(cur+k)%n] != rank[(prev+k)%n] {
+				classes++
+			}
+			newRank[cur] = classes - 1
+		}
+		rank, newRank = newRank, rank
+	}
+	return rotations
+}
+
+// moveToFront replaces every byte with the number of different bytes seen since it was last
+// seen, which turns the runs the Burrows-Wheeler transform makes into runs of zeros
+// data: The data to be coded
+func moveToFront(data []byte) []byte {
+	var order [common.ALPHABET_SIZE]byte
+	for i := range order {
+		order[i] = byte(i)
+	}
+
+	out := make([]byte, len(data))
+	for i, b := range data {
+		j := 0
+		for order[j] != b {
+			j++
+		}
+		copy(order[1:j+1], order[:j])
+		order[0] = b
+		out[i] = byte(j)
+	}
+	return out
+}
+
+// runLengthSymbols turns move-to-front values into symbols of the BWT alphabet, runs of zeros are
+// written as their length in RUN_A and RUN_B digits and other values move up by one
+// values: The move-to-front values
+func runLengthSymbols(values []byte) []uint16 {
+	symbols := make([]uint16, 0, len(values))
+	run := 0
+	for i := 0; i <= len(values); i++ {
+		if i < len(values) && values[i] == 0 {
+			run++
+			continue
+		}
+		for ; run > 0; run = (run - 1) / 2 {
+			if run&1 == 1 {
+				symbols = append(symbols, common.RUN_A)
+			} else {
+				symbols = append(symbols, common.RUN_B)
+				run--
+			}
+		}
+		if i < len(values) {
+			symbols = append(symbols, uint16(values[i])+1)
+		}
+	}
+	return symbols
+}
+
+// bwtTableCount returns the number of code tables to use, short blocks can't make up the cost of
+// storing many tables
+// symbols: The number of symbols in the block
+func bwtTableCount(symbols int) int {
+	switch {
+	case symbols < 200:
+		return 2
+	case symbols < 600:
+		return 3
+	case symbols < 1200:
+		return 4
+	case symbols < 2400:
+		return 5
+	}
+	return common.MAX_BWT_TABLES
+}
+
+// chooseTables works out the code lengths of each of a block's tables and which table codes each
+// group of symbols. The tables start out favouring different ranges of symbols, then every group
+// picks the cheapest table and each table is rebuilt from the groups that picked it.
+// Returns the code lengths of each table and the table of each group.
+// symbols: The symbols of the block, must not be empty
+// maxCodeLength: The longest code allowed
+func chooseTables(symbols []uint16, maxCodeLength int) ([][]uint8, []uint8, error) {
+	var frequencies [common.BWT_ALPHABET_SIZE]int
+	for _, symbol := range symbols {
+		frequencies[symbol]++
+	}
+
+	// Split the alphabet into ranges of about the same number of symbols, each table gives its
+	// own range short codes
+	lengths := make([][]uint8, bwtTableCount(len(symbols)))
+	remaining := len(symbols)
+	low := 0
+	for t := range lengths {
+		target := remaining / (len(lengths) - t)
+		high, sum := low, 0
+		for high < common.BWT_ALPHABET_SIZE && (sum < target || high == low) {
+			sum += frequencies[high]
+			high++
+		}
+		remaining -= sum
+
+		lengths[t] = make([]uint8, common.BWT_ALPHABET_SIZE)
+		for symbol := range lengths[t] {
+			if symbol < low || symbol >= high {
+				lengths[t][symbol] = 15
+			}
+		}
+		low = high
+	}
+
+	selectors := make([]uint8, (len(symbols)+common.BWT_GROUP_SIZE-1)/common.BWT_GROUP_SIZE)
+	counts := make([][common.BWT_ALPHABET_SIZE]int, len(lengths))
+	for iteration := 0; ; iteration++ {
+		for t := range counts {
+			counts[t] = [common.BWT_ALPHABET_SIZE]int{}
+		}
+		for g := range selectors {
+			group := symbols[g*common.BWT_GROUP_SIZE:]
+			if len(group) > common.BWT_GROUP_SIZE {
+				group = group[:common.BWT_GROUP_SIZE]
+			}
+
+			best, bestCost := 0, -1
+			for t := range lengths {
+				cost := 0
+				for _, symbol := range group {
+					cost += int(lengths[t][symbol])
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = t, cost
+				}
+			}
+			selectors[g] = uint8(best)
+			for _, symbol := range group {
+				counts[best][symbol]++
+			}
+		}
+		if iteration == BWT_TABLE_ITERATIONS {
+			return lengths, selectors, nil
+		}
+
+		// Every table keeps a code for every symbol in the block, so any group can pick any table
+		for t := range lengths {
+			histogram := make(map[uint32]int)
+			for symbol, frequency := range frequencies {
+				if frequency != 0 {
+					histogram[uint32(symbol)] = counts[t][symbol] + 1
+				}
+			}
+			var err error
+			if lengths[t], err = deflateCodeLengths(histogram, common.BWT_ALPHABET_SIZE, maxCodeLength); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+}
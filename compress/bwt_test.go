@@ -0,0 +1,84 @@
+package compress
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"io.whypeople/huffman/common"
+)
+
+// naiveBurrowsWheeler sorts every rotation of data as a string
+// data: The data to be transformed, must not be empty
+func naiveBurrowsWheeler(data []byte) []byte {
+	rotations := make([][]byte, len(data))
+	for i := range data {
+		rotations[i] = append(append([]byte{}, data[i:]...), data[:i]...)
+	}
+	sort.Slice(rotations, func(i, j int) bool { return bytes.Compare(rotations[i], rotations[j]) < 0 })
+	last := make([]byte, len(data))
+	for row, rotation := range rotations {
+		last[row] = rotation[len(rotation)-1]
+	}
+	return last
+}
+
+func TestBurrowsWheelerMatchesNaiveSort(t *testing.T) {
+	r := rand.New(rand.NewSource(12))
+	inputs := [][]byte{
+		[]byte("banana"), []byte("abracadabra"), []byte("a"), []byte("aaaaaaa"),
+		[]byte("abababab"), []byte("abcabcabcab"), []byte("mississippi\x00"),
+	}
+	for i := 0; i < 50; i++ {
+		data := make([]byte, 1+r.Intn(300))
+		for j := range data {
+			data[j] = byte('a' + r.Intn(1+i%4))
+		}
+		inputs = append(inputs, data)
+	}
+
+	for _, data := range inputs {
+		last, origin := burrowsWheeler(data)
+		if want := naiveBurrowsWheeler(data); !bytes.Equal(last, want) {
+			t.Fatalf("%q transformed to %q, expected %q", data, last, want)
+		}
+
+		// The origin is a row whose rotation is the data itself, which ends with its last byte
+		rotations := sortRotations(data)
+		start := int(rotations[origin])
+		if rotated := append(append([]byte{}, data[start:]...), data[:start]...); !bytes.Equal(rotated, data) {
+			t.Fatalf("%q has origin %d, which is the rotation %q", data, origin, rotated)
+		}
+	}
+}
+
+func TestBWTRoundTrip(t *testing.T) {
+	inputs := varietyInputs()
+
+	// A stream of more than one BWT block, the last one short
+	var big []byte
+	for len(big) < common.BWT_BLOCK_SIZE+50000 {
+		big = append(big, inputs[0].data...)
+	}
+	inputs = append(inputs, namedInput{"more than a block", big})
+
+	for _, input := range inputs {
+		t.Run(input.name, func(t *testing.T) {
+			encodeWith(t, input.data, WithBackend(BACKEND_BWT))
+		})
+	}
+}
+
+func TestBWTBeatsHuffmanOnText(t *testing.T) {
+	for _, input := range varietyInputs() {
+		if input.name != "mixed text" && input.name != "short period" && input.name != "long runs" {
+			continue
+		}
+		huffman := encodeWith(t, input.data)
+		bwt := encodeWith(t, input.data, WithBackend(BACKEND_BWT))
+		if 2*len(bwt) > len(huffman) {
+			t.Errorf("%s: bwt compressed to %d bytes, huffman to %d", input.name, len(bwt), len(huffman))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package compress
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"io.whypeople/huffman/common"
+	"io.whypeople/huffman/decompress"
+)
+
+// cancelOnOutput cancels a context once a file has some data in it, so the work writing the file
+// is stopped part way through. Returns a function that waits for the watcher to stop.
+// ctx: The context of the work, the watcher stops when it's done
+// cancel: Cancels the context
+// file: The file being written
+func cancelOnOutput(ctx context.Context, cancel context.CancelFunc, file *os.File) func() {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for ctx.Err() == nil {
+			if info, err := file.Stat(); err == nil && info.Size() > 0 {
+				cancel()
+				return
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	return func() { <-stopped }
+}
+
+// How long goroutines that have been told to stop get to exit before they count as leaked
+const GOROUTINE_EXIT_DEADLINE = time.Second
+
+// checkGoroutines fails the test if more goroutines are running than before the work started.
+// A goroutine that has just finished can take a moment to be counted out on a busy machine, so
+// the count is polled until GOROUTINE_EXIT_DEADLINE. A goroutine the work failed to stop is
+// blocked for good and is still there at the deadline.
+// t: The test
+// baseline: The number of goroutines before the work started
+func checkGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(GOROUTINE_EXIT_DEADLINE)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		buf := make([]byte, 1<<16)
+		t.Fatalf("%d goroutines are running, %d were before\n%s", n, baseline, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+// cancelFiles creates a file of data to compress, the stream it compresses to and a file to
+// write to, in a fresh directory
+// t: The test
+func cancelFiles(t *testing.T) (*os.File, *os.File, *os.File) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data"), randomBytes(100*common.STREAM_BLOCK_SIZE, 5), 0600); err != nil {
+		t.Fatal(err)
+	}
+	files := make([]*os.File, 3)
+	for i, name := range []string{"data", "stream", "out"} {
+		file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { file.Close() })
+		files[i] = file
+	}
+	if _, err := CompressFile(files[0], files[1], 8); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files[:2] {
+		if _, err := file.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files[0], files[1], files[2]
+}
+
+func TestCompressContextCancel(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	data, _, out := cancelFiles(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wait := cancelOnOutput(ctx, cancel, out)
+	_, err := CompressContext(ctx, data, out, 8)
+	cancel()
+	wait()
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	checkGoroutines(t, baseline)
+}
+
+func TestDecompressContextCancel(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	_, stream, out := cancelFiles(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wait := cancelOnOutput(ctx, cancel, out)
+	_, err := decompress.DecompressContext(ctx, stream, out, 8)
+	cancel()
+	wait()
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	checkGoroutines(t, baseline)
+}
+
+func TestContextCanceledBeforeStart(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	data, stream, out := cancelFiles(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := CompressContext(ctx, data, out, 8); !errors.Is(err, context.Canceled) {
+		t.Fatalf("compress: expected context.Canceled, got %v", err)
+	}
+	if _, err := decompress.DecompressContext(ctx, stream, out, 8); !errors.Is(err, context.Canceled) {
+		t.Fatalf("decompress: expected context.Canceled, got %v", err)
+	}
+	checkGoroutines(t, baseline)
+}
@@ -0,0 +1,242 @@
+package compress
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"sync"
+
+	"io.whypeople/huffman/common"
+)
+
+// The estimated size in bits of a code length dump, per symbol with a code and per run of symbols
+// without one
+const CONTEXT_LENGTH_COST = 4
+const CONTEXT_ZERO_RUN_COST = 12
+
+// The most clusters merging starts from, finding the best pair to merge takes time cubic in the
+// number of clusters
+const CONTEXT_MAX_CLUSTERS = 64
+
+// n*log2(n) for every count a block of the default size can hold, used to estimate how well a histogram codes
+var nLogN []float64
+var nLogNOnce sync.Once
+
+// A group of contexts that share a code table
+type contextCluster struct {
+	contexts  []int
+	histogram [common.ALPHABET_SIZE]int
+	cost      float64 // The estimated size in bits of the cluster's code length dump and coded symbols
+}
+
+// compressContextBlock codes every byte of a block with a code table picked by the byte before it,
+// the first byte of a block is coded as if it followed a 0. Contexts that code alike share a table.
+// Returns the block's dump and payload. The dump holds the number of tables, the table of every
+// context as runs of (table, run length - 1) and a code length dump for each table.
+// block: The uncompressed data, must not be empty
+// maxCodeLength: The longest code the block may use
+func compressContextBlock(block []byte, maxCodeLength int) ([]byte, []byte, error) {
+	var histograms [common.ALPHABET_SIZE][common.ALPHABET_SIZE]int
+	prev := byte(0)
+	for _, b := range block {
+		histograms[prev][b]++
+		prev = b
+	}
+	contextMap, clusters := clusterContexts(&histograms)
+
+	dump := []byte{uint8(len(clusters) - 1)}
+	for context := 0; context < common.ALPHABET_SIZE; {
+		run := 1
+		for context+run < common.ALPHABET_SIZE && run < 256 && contextMap[context+run] == contextMap[context] {
+			run++
+		}
+		dump = append(dump, contextMap[context], uint8(run-1))
+		context += run
+	}
+
+	// A table with a single symbol codes it with no bits at all
+	codeTables := make([]HuffCodeTable, len(clusters))
+	for i, cluster := range clusters {
+		histogram := make(map[uint32]int)
+		for symbol, count := range cluster.histogram {
+			if count != 0 {
+				histogram[uint32(symbol)] = count
+			}
+		}
+		lengths, err := codeLengths(histogram, common.ALPHABET_SIZE, maxCodeLength)
+		if err != nil {
+			return nil, nil, err
+		}
+		dump = append(dump, CreateCodeLengthDump(lengths)...)
+		if len(histogram) > 1 {
+			codeTables[i] = CodeLengthsToCodeTable(lengths)
+		}
+	}
+
+	out := new(bytes.Buffer)
+	bw := common.NewBitWriter(out)
+	prev = 0
+	for _, b := range block {
+		if codeTable := codeTables[contextMap[prev]]; codeTable != nil {
+			writeCode(bw, codeTable[uint32(b)])
+		}
+		prev = b
+	}
+	bw.Flush()
+	return dump, out.Bytes(), nil
+}
+
+// clusterContexts starts with a cluster for each of the most common contexts, with the rest of
+// the contexts sharing one more, and keeps merging the pair of clusters that saves the most until
+// merging would cost more in coded symbols than it saves in tables. Contexts that never occur
+// join the cluster of the context before them, which keeps the runs of the context map long.
+// Returns the cluster of every context and the clusters.
+// histograms: The histogram of the bytes that follow each context
+func clusterContexts(histograms *[common.ALPHABET_SIZE][common.ALPHABET_SIZE]int) ([common.ALPHABET_SIZE]uint8, []*contextCluster) {
+	contexts := make([]int, 0, common.ALPHABET_SIZE)
+	var totals [common.ALPHABET_SIZE]int
+	for context := range histograms {
+		for _, count := range histograms[context] {
+			totals[context] += count
+		}
+		if totals[context] != 0 {
+			contexts = append(contexts, context)
+		}
+	}
+	sort.SliceStable(contexts, func(i, j int) bool {
+		return totals[contexts[i]] > totals[contexts[j]]
+	})
+
+	clusters := make([]*contextCluster, 0, CONTEXT_MAX_CLUSTERS)
+	for i, context := range contexts {
+		if i < CONTEXT_MAX_CLUSTERS {
+			clusters = append(clusters, &contextCluster{contexts: []int{context}, histogram: histograms[context]})
+			continue
+		}
+		rare := clusters[CONTEXT_MAX_CLUSTERS-1]
+		rare.contexts = append(rare.contexts, context)
+		for symbol, count := range histograms[context] {
+			rare.histogram[symbol] += count
+		}
+	}
+	for _, cluster := range clusters {
+		cluster.cost = histogramCost(&cluster.histogram)
+	}
+
+	// savings[i][j] is what merging clusters i and j saves, for i < j
+	savings := make([][]float64, len(clusters))
+	for i := range clusters {
+		savings[i] = make([]float64, len(clusters))
+		for j := i + 1; j < len(clusters); j++ {
+			savings[i][j] = mergeSaving(clusters[i], clusters[j])
+		}
+	}
+
+	live := len(clusters)
+	for live > 1 {
+		bestI, bestJ, best := -1, -1, 0.0
+		for i := range clusters {
+			if clusters[i] == nil {
+				continue
+			}
+			for j := i + 1; j < len(clusters); j++ {
+				if clusters[j] != nil && savings[i][j] > best {
+					bestI, bestJ, best = i, j, savings[i][j]
+				}
+			}
+		}
+		if bestI < 0 {
+			break
+		}
+
+		merged := clusters[bestI]
+		merged.contexts = append(merged.contexts, clusters[bestJ].contexts...)
+		for symbol, count := range clusters[bestJ].histogram {
+			merged.histogram[symbol] += count
+		}
+		merged.cost = histogramCost(&merged.histogram)
+		clusters[bestJ] = nil
+		live--
+
+		for k := range clusters {
+			if clusters[k] == nil || k == bestI {
+				continue
+			}
+			if k < bestI {
+				savings[k][bestI] = mergeSaving(clusters[k], merged)
+			} else {
+				savings[bestI][k] = mergeSaving(merged, clusters[k])
+			}
+		}
+	}
+
+	var contextMap [common.ALPHABET_SIZE]uint8
+	var seen [common.ALPHABET_SIZE]bool
+	kept := make([]*contextCluster, 0, live)
+	for _, cluster := range clusters {
+		if cluster == nil {
+			continue
+		}
+		for _, context := range cluster.contexts {
+			contextMap[context] = uint8(len(kept))
+			seen[context] = true
+		}
+		kept = append(kept, cluster)
+	}
+	for context := 1; context < common.ALPHABET_SIZE; context++ {
+		if !seen[context] {
+			contextMap[context] = contextMap[context-1]
+		}
+	}
+	return contextMap, kept
+}
+
+// mergeSaving returns the number of bits merging two clusters is estimated to save
+// a: The first cluster
+// b: The second cluster
+func mergeSaving(a *contextCluster, b *contextCluster) float64 {
+	var merged [common.ALPHABET_SIZE]int
+	for symbol := range merged {
+		merged[symbol] = a.histogram[symbol] + b.histogram[symbol]
+	}
+	return a.cost + b.cost - histogramCost(&merged)
+}
+
+// histogramCost estimates the size in bits of a code length dump for a histogram and of the
+// symbols it counts, coded at their entropy. Returns 0 for an empty histogram.
+// histogram: The number of times each symbol occurs
+func histogramCost(histogram *[common.ALPHABET_SIZE]int) float64 {
+	total := 0
+	bits := 0.0
+	tableBits := 0
+	for symbol, count := range histogram {
+		if count == 0 {
+			if symbol == 0 || histogram[symbol-1] != 0 {
+				tableBits += CONTEXT_ZERO_RUN_COST
+			}
+			continue
+		}
+		total += count
+		bits -= countLog(count)
+		tableBits += CONTEXT_LENGTH_COST
+	}
+	if total == 0 {
+		return 0
+	}
+	return bits + countLog(total) + float64(tableBits)
+}
+
+// countLog returns n * log2(n), from a table for counts up to the default block size
+// n: The count, at least 1
+func countLog(n int) float64 {
+	nLogNOnce.Do(func() {
+		nLogN = make([]float64, common.STREAM_BLOCK_SIZE+1)
+		for n := 1; n < len(nLogN); n++ {
+			nLogN[n] = float64(n) * math.Log2(float64(n))
+		}
+	})
+	if n < len(nLogN) {
+		return nLogN[n]
+	}
+	return float64(n) * math.Log2(float64(n))
+}
@@ -0,0 +1,90 @@
+package compress
+
+import (
+	"math/rand"
+	"testing"
+
+	"io.whypeople/huffman/common"
+)
+
+// The number of different bytes in markovBytes, about as many as text uses
+const MARKOV_SYMBOLS = 48
+
+// markovBytes returns bytes where each byte is almost always decided by the one before it, but
+// every byte is about as common as any other
+// n: The number of bytes
+// seed: The seed of the random source
+func markovBytes(n int, seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	next := r.Perm(MARKOV_SYMBOLS)
+	data := make([]byte, n)
+	prev := 0
+	for i := range data {
+		if r.Intn(10) == 0 {
+			prev = r.Intn(MARKOV_SYMBOLS)
+		} else {
+			prev = next[prev]
+		}
+		data[i] = byte('0' + prev)
+	}
+	return data
+}
+
+func TestContextRoundTrip(t *testing.T) {
+	inputs := append(varietyInputs(), namedInput{"markov", markovBytes(3*common.STREAM_BLOCK_SIZE, 13)})
+	for _, input := range inputs {
+		t.Run(input.name, func(t *testing.T) {
+			encodeWith(t, input.data, WithBackend(BACKEND_CONTEXT))
+		})
+	}
+}
+
+func TestClusterContexts(t *testing.T) {
+	for _, input := range append(varietyInputs(), namedInput{"markov", markovBytes(common.STREAM_BLOCK_SIZE, 14)}) {
+		var histograms [common.ALPHABET_SIZE][common.ALPHABET_SIZE]int
+		prev := byte(0)
+		for _, b := range input.data[:common.STREAM_BLOCK_SIZE] {
+			histograms[prev][b]++
+			prev = b
+		}
+		contextMap, clusters := clusterContexts(&histograms)
+		if len(clusters) == 0 || len(clusters) > CONTEXT_MAX_CLUSTERS {
+			t.Fatalf("%s: %d clusters", input.name, len(clusters))
+		}
+
+		// Every context's bytes are counted in the cluster it maps to
+		var counted [CONTEXT_MAX_CLUSTERS][common.ALPHABET_SIZE]int
+		for context, cluster := range contextMap {
+			if int(cluster) >= len(clusters) {
+				t.Fatalf("%s: context %d maps to cluster %d of %d", input.name, context, cluster, len(clusters))
+			}
+			for b, count := range histograms[context] {
+				counted[cluster][b] += count
+			}
+		}
+		for i, cluster := range clusters {
+			if counted[i] != cluster.histogram {
+				t.Fatalf("%s: cluster %d doesn't hold the histograms of its contexts", input.name, i)
+			}
+		}
+	}
+}
+
+func TestContextBeatsHuffmanWhenBytesPredictTheNext(t *testing.T) {
+	// Every byte is about as common as any other, so order-0 coding can't shrink it much
+	data := markovBytes(3*common.STREAM_BLOCK_SIZE, 15)
+	huffman := encodeWith(t, data)
+	context := encodeWith(t, data, WithBackend(BACKEND_CONTEXT))
+	if 2*len(context) > len(huffman) {
+		t.Fatalf("context compressed to %d bytes, huffman to %d", len(context), len(huffman))
+	}
+
+	// Random bytes have nothing for the contexts to predict, the blocks are stored
+	random := varietyInputs()[5]
+	if random.name != "random" {
+		t.Fatalf("expected the random input, got %s", random.name)
+	}
+	if huffman, context := encodeWith(t, random.data), encodeWith(t, random.data, WithBackend(BACKEND_CONTEXT)); len(context) != len(huffman) {
+		t.Fatalf("random bytes compressed to %d bytes with contexts, %d without", len(context), len(huffman))
+	}
+}
@@ -0,0 +1,265 @@
+package compress
+
+import (
+	"io.whypeople/huffman/common"
+)
+
+// A code length, or a run of code lengths, in the header of a dynamic DEFLATE block
+type lengthToken struct {
+	symbol uint8 // A code length, or one of the run symbols
+	extra  uint8 // The extra bits of a run symbol
+}
+
+// The code lengths of a dynamic DEFLATE block and how they're stored in its header
+type dynamicHeader struct {
+	literalLengths    []uint8
+	distanceLengths   []uint8
+	codeLengthLengths []uint8 // The code lengths of the code length alphabet
+	lengthTokens      []lengthToken
+	literalCount      int // The number of literal/length code lengths stored
+	distanceCount     int // The number of distance code lengths stored
+	codeLengthCount   int // The number of code length alphabet code lengths stored
+}
+
+// writeDeflateBlock LZ77 codes a block of data and appends it to a DEFLATE stream as whichever of
+// a stored, fixed or dynamic block is smallest. Matches don't reach back into earlier blocks.
+// bw: The bit writer, which carries on from the previous block
+// data: The data to be encoded, it can be empty
+// final: Whether this is the last block of the stream
+func writeDeflateBlock(bw *common.BitWriter, data []byte, final bool) error {
+	tokens := findMatches(data, common.MAX_LZ77_WINDOW)
+	literals, distances := countTokens(tokens)
+
+	fixedLiterals := common.FixedLiteralLengths()
+	fixedDistances := common.FixedDistanceLengths()
+	header, err := newDynamicHeader(literals, distances)
+	if err != nil {
+		return err
+	}
+
+	storedCost := storedBlockCost(len(data))
+	fixedCost := 3 + tokenCost(literals, distances, fixedLiterals, fixedDistances)
+	dynamicCost := 3 + header.cost() + tokenCost(literals, distances, header.literalLengths, header.distanceLengths)
+
+	finalBit := uint32(0)
+	if final {
+		finalBit = 1
+	}
+	switch {
+	case storedCost <= fixedCost && storedCost <= dynamicCost:
+		writeStoredBlocks(bw, data, final)
+	case fixedCost <= dynamicCost:
+		bw.WriteBits(uint64(finalBit|common.DEFLATE_FIXED<<1), 3)
+		writeTokens(bw, tokens, CodeLengthsToCodeTable(fixedLiterals), CodeLengthsToCodeTable(fixedDistances))
+	default:
+		bw.WriteBits(uint64(finalBit|common.DEFLATE_DYNAMIC<<1), 3)
+		header.append(bw)
+		writeTokens(bw, tokens, CodeLengthsToCodeTable(header.literalLengths), CodeLengthsToCodeTable(header.distanceLengths))
+	}
+	return nil
+}
+
+// writeStoredBlocks writes data as one or more stored blocks, which hold it uncompressed
+// bw: The bit writer
+// data: The data to be stored
+// final: Whether the last of the blocks is the last block of the stream
+func writeStoredBlocks(bw *common.BitWriter, data []byte, final bool) {
+	for first := true; first || len(data) > 0; first = false {
+		chunk := data
+		if len(chunk) > common.MAX_STORED_BLOCK_SIZE {
+			chunk = chunk[:common.MAX_STORED_BLOCK_SIZE]
+		}
+		data = data[len(chunk):]
+
+		finalBit := uint32(0)
+		if final && len(data) == 0 {
+			finalBit = 1
+		}
+		bw.WriteBits(uint64(finalBit|common.DEFLATE_STORED<<1), 3)
+
+		// The length and its complement start on a byte boundary
+		bw.AlignToByte()
+		bw.WriteBits(uint64(len(chunk)), 16)
+		bw.WriteBits(uint64(^uint16(len(chunk))), 16)
+		for _, b := range chunk {
+			bw.WriteBits(uint64(b), 8)
+		}
+	}
+}
+
+// storedBlockCost returns the most bits storing data in stored blocks could take
+// size: The size of the data
+func storedBlockCost(size int) int {
+	blocks := (size + common.MAX_STORED_BLOCK_SIZE - 1) / common.MAX_STORED_BLOCK_SIZE
+	if blocks == 0 {
+		blocks = 1
+	}
+	return blocks*(3+7+32) + size*8
+}
+
+// tokenCost returns the number of bits it takes to code the symbols of both alphabets, including
+// their extra bits and the end of block symbol
+// literals: The literal/length histogram
+// distances: The distance histogram
+// literalLengths: The literal/length code lengths
+// distanceLengths: The distance code lengths
+func tokenCost(literals map[uint32]int, distances map[uint32]int, literalLengths []uint8, distanceLengths []uint8) int {
+	cost := 0
+	for symbol, count := range literals {
+		bits := int(literalLengths[symbol])
+		if symbol >= common.FIRST_LENGTH_SYMBOL {
+			bits += int(common.LengthExtraBits[symbol-common.FIRST_LENGTH_SYMBOL])
+		}
+		cost += count * bits
+	}
+	for symbol, count := range distances {
+		cost += count * (int(distanceLengths[symbol]) + int(common.DistanceExtraBits[symbol]))
+	}
+	return cost
+}
+
+// newDynamicHeader works out the code lengths of a dynamic block and how to store them
+// literals: The literal/length histogram
+// distances: The distance histogram
+func newDynamicHeader(literals map[uint32]int, distances map[uint32]int) (*dynamicHeader, error) {
+	h := &dynamicHeader{}
+	var err error
+	if h.literalLengths, err = deflateCodeLengths(literals, common.LITERAL_LENGTH_ALPHABET_SIZE, common.MAX_DEFLATE_CODE_LENGTH); err != nil {
+		return nil, err
+	}
+	if h.distanceLengths, err = deflateCodeLengths(distances, common.DISTANCE_ALPHABET_SIZE, common.MAX_DEFLATE_CODE_LENGTH); err != nil {
+		return nil, err
+	}
+
+	// Trailing zero lengths aren't stored, but there's always at least one of each kind
+	h.literalCount = storedLengthCount(h.literalLengths, common.FIRST_LENGTH_SYMBOL)
+	h.distanceCount = storedLengthCount(h.distanceLengths, 1)
+	lengths := append(append([]uint8{}, h.literalLengths[:h.literalCount]...), h.distanceLengths[:h.distanceCount]...)
+	h.lengthTokens = runLengthTokens(lengths)
+
+	// The lengths are coded with their own code, whose lengths are stored in a fixed order
+	histogram := make(map[uint32]int)
+	for _, t := range h.lengthTokens {
+		histogram[uint32(t.symbol)]++
+	}
+	if h.codeLengthLengths, err = deflateCodeLengths(histogram, common.CODE_LENGTH_ALPHABET_SIZE, common.MAX_CODE_LENGTH_CODE_LENGTH); err != nil {
+		return nil, err
+	}
+	h.codeLengthCount = common.CODE_LENGTH_ALPHABET_SIZE
+	for h.codeLengthCount > 4 && h.codeLengthLengths[common.CodeLengthOrder[h.codeLengthCount-1]] == 0 {
+		h.codeLengthCount--
+	}
+	return h, nil
+}
+
+// cost returns the size of the header in bits
+func (h *dynamicHeader) cost() int {
+	cost := 5 + 5 + 4 + 3*h.codeLengthCount
+	for _, t := range h.lengthTokens {
+		cost += int(h.codeLengthLengths[t.symbol]) + int(runExtraBits(t.symbol))
+	}
+	return cost
+}
+
+// append writes the header to the bit writer
+// bw: The bit writer
+func (h *dynamicHeader) append(bw *common.BitWriter) {
+	bw.WriteBits(uint64(h.literalCount-common.FIRST_LENGTH_SYMBOL), 5)
+	bw.WriteBits(uint64(h.distanceCount-1), 5)
+	bw.WriteBits(uint64(h.codeLengthCount-4), 4)
+	for _, symbol := range common.CodeLengthOrder[:h.codeLengthCount] {
+		bw.WriteBits(uint64(h.codeLengthLengths[symbol]), 3)
+	}
+
+	codes := CodeLengthsToCodeTable(h.codeLengthLengths)
+	for _, t := range h.lengthTokens {
+		writeCode(bw, codes[uint32(t.symbol)])
+		bw.WriteBits(uint64(t.extra), uint(runExtraBits(t.symbol)))
+	}
+}
+
+// deflateCodeLengths is like codeLengths but always gives at least two symbols a code. DEFLATE
+// decoders are allowed to reject codes that don't use up every bit pattern, which a lone symbol's
+// code doesn't.
+// histogram: The number of times each symbol occurs, unused symbols are added to it
+// alphabetSize: The number of symbols in the alphabet
+// maxCodeLength: The longest code allowed
+func deflateCodeLengths(histogram map[uint32]int, alphabetSize int, maxCodeLength int) ([]uint8, error) {
+	for symbol := uint32(0); len(histogram) < 2; symbol++ {
+		if _, ok := histogram[symbol]; !ok {
+			histogram[symbol] = 0
+		}
+	}
+	return codeLengths(histogram, alphabetSize, maxCodeLength)
+}
+
+// storedLengthCount returns the number of code lengths left once trailing zeros are dropped
+// lengths: The code lengths
+// minimum: The fewest lengths that can be stored
+func storedLengthCount(lengths []uint8, minimum int) int {
+	count := len(lengths)
+	for count > minimum && lengths[count-1] == 0 {
+		count--
+	}
+	return count
+}
+
+// runLengthTokens codes a list of code lengths with the code length alphabet, replacing runs of
+// lengths with the run symbols
+// lengths: The code lengths
+func runLengthTokens(lengths []uint8) []lengthToken {
+	tokens := make([]lengthToken, 0, len(lengths))
+	for i := 0; i < len(lengths); {
+		length := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == length {
+			run++
+		}
+		i += run
+
+		if length == 0 {
+			for run >= 11 {
+				n := run
+				if n > 138 {
+					n = 138
+				}
+				tokens = append(tokens, lengthToken{common.REPEAT_ZERO_LONG, uint8(n - 11)})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, lengthToken{common.REPEAT_ZERO, uint8(run - 3)})
+				run = 0
+			}
+		} else {
+			// A run of the previous length needs the length to be written once first
+			tokens = append(tokens, lengthToken{length, 0})
+			run--
+			for run >= 3 {
+				n := run
+				if n > 6 {
+					n = 6
+				}
+				tokens = append(tokens, lengthToken{common.REPEAT_PREVIOUS, uint8(n - 3)})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, lengthToken{length, 0})
+		}
+	}
+	return tokens
+}
+
+// runExtraBits returns the number of extra bits that follow a symbol of the code length alphabet
+// symbol: The symbol
+func runExtraBits(symbol uint8) uint8 {
+	switch symbol {
+	case common.REPEAT_PREVIOUS:
+		return 2
+	case common.REPEAT_ZERO:
+		return 3
+	case common.REPEAT_ZERO_LONG:
+		return 7
+	}
+	return 0
+}
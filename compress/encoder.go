@@ -0,0 +1,282 @@
+package compress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"io.whypeople/huffman/common"
+)
+
+// The entropy coders an Encoder can compress with
+const BACKEND_HUFFMAN = "huffman"   // A canonical Huffman code for every block
+const BACKEND_ADAPTIVE = "adaptive" // An adaptive Huffman tree that's updated as each symbol is coded
+const BACKEND_LZ77 = "lz77"         // LZ77 matches, then canonical Huffman codes
+const BACKEND_BWT = "bwt"           // A Burrows-Wheeler transform, then several canonical Huffman codes
+const BACKEND_CONTEXT = "context"   // A canonical Huffman code picked by the byte before each byte
+const BACKEND_GZIP = "gzip"         // A gzip member, which ignores the block size, code length, checksum and memory options
+
+// Option configures an Encoder
+type Option func(*Encoder) error
+
+// Encoder compresses files and streams with a fixed set of options. It keeps its writer and block
+// buffers between inputs, so compressing many inputs with one Encoder doesn't reallocate them.
+// An Encoder must not be used by several goroutines at once.
+type Encoder struct {
+	concurrency   int
+	blockSize     int // 0 for the backend's default
+	maxCodeLength int
+	checksum      int
+	backend       string
+	window        int   // The LZ77 window
+	memoryLimit   int64 // 0 for no limit
+	writer        *Writer
+	gzip          *GzipWriter
+}
+
+// NewEncoder returns an Encoder. Without options it codes with BACKEND_HUFFMAN on a goroutine
+// for every CPU, with the default block size, the longest codes a stream can hold and CRC-32C
+// checksums.
+// opts: The options to apply, in order
+func NewEncoder(opts ...Option) (*Encoder, error) {
+	e := &Encoder{
+		concurrency:   runtime.NumCPU(),
+		maxCodeLength: common.MAX_CODE_SIZE,
+		checksum:      common.CHECKSUM_CRC32C,
+		backend:       BACKEND_HUFFMAN,
+		window:        common.MAX_LZ77_WINDOW,
+	}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.backend == BACKEND_GZIP {
+		e.gzip = NewGzipWriter(io.Discard)
+		return e, nil
+	}
+	if shortest := minCodeLength(backendAlphabetSize(e.backend)); e.maxCodeLength < shortest {
+		return nil, fmt.Errorf("max code length of the %s backend must be at least %d bits", e.backend, shortest)
+	}
+	z, err := NewWriterMaxCodeLength(io.Discard, e.maxCodeLength)
+	if err != nil {
+		return nil, err
+	}
+	switch e.backend {
+	case BACKEND_ADAPTIVE:
+		z.adaptive = true
+	case BACKEND_LZ77:
+		z.window = e.window
+	case BACKEND_BWT:
+		z.bwt = true
+		z.buf = make([]byte, 0, common.BWT_BLOCK_SIZE)
+	case BACKEND_CONTEXT:
+		z.context = true
+	}
+	if e.blockSize != 0 {
+		z.buf = make([]byte, 0, e.blockSize)
+	}
+	z.checksum = e.checksum
+	e.writer = z
+
+	if e.memoryLimit != 0 && e.blockMemory() > e.memoryLimit {
+		return nil, fmt.Errorf("compressing a block takes about %d bytes, more than the memory limit of %d", e.blockMemory(), e.memoryLimit)
+	}
+	return e, nil
+}
+
+// WithConcurrency sets the most goroutines that compress blocks of a file at once
+// n: The number of goroutines, at least 1
+func WithConcurrency(n int) Option {
+	return func(e *Encoder) error {
+		if n < 1 {
+			return errors.New("concurrency must be at least 1")
+		}
+		e.concurrency = n
+		return nil
+	}
+}
+
+// WithBlockSize sets how much data goes in each block, smaller blocks can be decoded at random
+// with less work and bigger ones compress a little better. The block size is the seek interval
+// of decompress.ReaderAt, which decodes whole blocks.
+// size: The block size, from MIN_BLOCK_SIZE to MAX_BLOCK_SIZE bytes
+func WithBlockSize(size int) Option {
+	return func(e *Encoder) error {
+		if size < common.MIN_BLOCK_SIZE || size > common.MAX_BLOCK_SIZE {
+			return fmt.Errorf("block size must be between %d and %d bytes", common.MIN_BLOCK_SIZE, common.MAX_BLOCK_SIZE)
+		}
+		e.blockSize = size
+		return nil
+	}
+}
+
+// WithMaxCodeLength limits the length of every code, smaller limits let decoders use smaller
+// tables at a small cost in compression. BACKEND_LZ77 and BACKEND_BWT code more than 256 symbols
+// and need at least 9 bits.
+// n: The longest code allowed, from MIN_CODE_LENGTH_LIMIT to MAX_CODE_SIZE bits
+func WithMaxCodeLength(n int) Option {
+	return func(e *Encoder) error {
+		if n < common.MIN_CODE_LENGTH_LIMIT || n > common.MAX_CODE_SIZE {
+			return fmt.Errorf("max code length must be between %d and %d bits", common.MIN_CODE_LENGTH_LIMIT, common.MAX_CODE_SIZE)
+		}
+		e.maxCodeLength = n
+		return nil
+	}
+}
+
+// WithChecksum sets the checksum streams carry
+// checksum: CHECKSUM_NONE or CHECKSUM_CRC32C
+func WithChecksum(checksum int) Option {
+	return func(e *Encoder) error {
+		if checksum != common.CHECKSUM_NONE && checksum != common.CHECKSUM_CRC32C {
+			return fmt.Errorf("unknown checksum type %d", checksum)
+		}
+		e.checksum = checksum
+		return nil
+	}
+}
+
+// WithBackend sets the entropy coder blocks are compressed with
+// backend: One of the BACKEND constants
+func WithBackend(backend string) Option {
+	return func(e *Encoder) error {
+		switch backend {
+		case BACKEND_HUFFMAN, BACKEND_ADAPTIVE, BACKEND_LZ77, BACKEND_BWT, BACKEND_CONTEXT, BACKEND_GZIP:
+			e.backend = backend
+			return nil
+		}
+		return fmt.Errorf("unknown backend %q", backend)
+	}
+}
+
+// WithLZ77Window sets how far back an LZ77 match can be, it only matters for BACKEND_LZ77
+// window: A power of two from MIN_LZ77_WINDOW to MAX_LZ77_WINDOW
+func WithLZ77Window(window int) Option {
+	return func(e *Encoder) error {
+		if window < common.MIN_LZ77_WINDOW || window > common.MAX_LZ77_WINDOW || window&(window-1) != 0 {
+			return fmt.Errorf("LZ77 window must be a power of two between %d and %d", common.MIN_LZ77_WINDOW, common.MAX_LZ77_WINDOW)
+		}
+		e.window = window
+		return nil
+	}
+}
+
+// WithMemoryLimit limits the memory compressing a file takes. Fewer blocks are compressed at
+// once to stay under the limit, down to one at a time.
+// limit: The limit in bytes, at least enough to compress one block
+func WithMemoryLimit(limit int64) Option {
+	return func(e *Encoder) error {
+		if limit < 1 {
+			return errors.New("memory limit must be positive")
+		}
+		e.memoryLimit = limit
+		return nil
+	}
+}
+
+// Writer returns the Encoder's writer, reset to compress a new stream to w. The same writer is
+// returned every time, so it must be closed before Writer is called again.
+// w: The writer to write the compressed stream to
+func (e *Encoder) Writer(w io.Writer) io.WriteCloser {
+	if e.gzip != nil {
+		e.gzip.Reset(w)
+		return e.gzip
+	}
+	e.writer.Reset(w)
+	return e.writer
+}
+
+// Encode compresses everything read from src into a stream written to dst
+// dst: The writer to write the compressed stream to
+// src: The data to be compressed
+func (e *Encoder) Encode(dst io.Writer, src io.Reader) error {
+	return e.EncodeContext(context.Background(), dst, src)
+}
+
+// EncodeContext is like Encode but stops and returns ctx.Err() once ctx is done, the context is
+// checked between reads of src
+// ctx: The context that stops compression
+// dst: The writer to write the compressed stream to
+// src: The data to be compressed
+func (e *Encoder) EncodeContext(ctx context.Context, dst io.Writer, src io.Reader) error {
+	writer := e.Writer(dst)
+	if _, err := io.Copy(writer, common.NewContextReader(ctx, src)); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// EncodeFile compresses a file, blocks of seekable files are compressed concurrently
+// infile: The file to be compressed
+// outfile: The file to write the compressed data to
+func (e *Encoder) EncodeFile(infile *os.File, outfile *os.File) (*os.File, error) {
+	return e.EncodeFileContext(context.Background(), infile, outfile)
+}
+
+// EncodeFileContext is like EncodeFile but stops every goroutine and returns ctx.Err() once ctx is done
+// ctx: The context that stops compression
+// infile: The file to be compressed
+// outfile: The file to write the compressed data to
+func (e *Encoder) EncodeFileContext(ctx context.Context, infile *os.File, outfile *os.File) (*os.File, error) {
+	// Make sure file pointers are valid
+	if infile == nil || outfile == nil {
+		return nil, errors.New("infile and outfile cannot be nil")
+	}
+
+	// Gzip members are always streamed
+	if e.gzip != nil {
+		if err := e.EncodeContext(ctx, outfile, infile); err != nil {
+			return nil, err
+		}
+		return outfile, nil
+	}
+
+	// Blocks in flight are limited to twice the goroutines, plus the one being written
+	concurrency := e.concurrency
+	if e.memoryLimit != 0 {
+		if fit := int((e.memoryLimit/e.blockMemory() - 1) / 2); fit < concurrency {
+			concurrency = fit
+		}
+	}
+	e.writer.Reset(outfile)
+	if concurrency < 1 {
+		if err := e.EncodeContext(ctx, outfile, infile); err != nil {
+			return nil, err
+		}
+		return outfile, nil
+	}
+	return compressFile(ctx, infile, outfile, concurrency, e.writer)
+}
+
+// backendAlphabetSize returns the number of symbols a backend codes with one Huffman code
+// backend: One of the BACKEND constants
+func backendAlphabetSize(backend string) int {
+	switch backend {
+	case BACKEND_LZ77:
+		return common.LITERAL_LENGTH_ALPHABET_SIZE
+	case BACKEND_BWT:
+		return common.BWT_ALPHABET_SIZE
+	}
+	return common.ALPHABET_SIZE
+}
+
+// blockMemory estimates the memory it takes to compress a block, counting its data, the
+// backend's working memory and the compressed block
+func (e *Encoder) blockMemory() int64 {
+	n := int64(cap(e.writer.buf))
+	switch e.backend {
+	case BACKEND_LZ77:
+		// Up to a 4 byte token for every byte, and the match finder's hash chains
+		return 6*n + 4*int64(e.window) + 4<<LZ77_HASH_BITS
+	case BACKEND_BWT:
+		// The suffix sort keeps five int32s for every byte, and the transformed data is coded
+		// as 16 bit symbols
+		return 26 * n
+	}
+	return 2 * n
+}
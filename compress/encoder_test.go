@@ -0,0 +1,93 @@
+package compress
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"io.whypeople/huffman/common"
+	"io.whypeople/huffman/decompress"
+)
+
+// randomBytes returns n bytes of data from a fixed seed, skewed so that it compresses a little
+// n: The number of bytes
+// seed: The seed of the data
+func randomBytes(n int, seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(r.Intn(16) * r.Intn(17))
+	}
+	return data
+}
+
+// decodeAll decompresses a stream and fails the test if it doesn't decode
+// t: The test
+// stream: The compressed stream
+func decodeAll(t *testing.T, stream []byte) []byte {
+	t.Helper()
+	d, err := decompress.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := d.Decode(&out, bytes.NewReader(stream)); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return out.Bytes()
+}
+
+func TestMaxCodeLengthPerBackend(t *testing.T) {
+	data := make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(data)
+	tests := []struct {
+		backend  string
+		shortest int
+	}{
+		{BACKEND_HUFFMAN, common.MIN_CODE_LENGTH_LIMIT},
+		{BACKEND_ADAPTIVE, common.MIN_CODE_LENGTH_LIMIT},
+		{BACKEND_LZ77, 9},
+		{BACKEND_BWT, 9},
+		{BACKEND_CONTEXT, common.MIN_CODE_LENGTH_LIMIT},
+	}
+	for _, test := range tests {
+		t.Run(test.backend, func(t *testing.T) {
+			if _, err := NewEncoder(WithBackend(test.backend), WithMaxCodeLength(test.shortest-1)); err == nil {
+				t.Fatalf("a limit of %d bits was accepted", test.shortest-1)
+			}
+			e, err := NewEncoder(WithBackend(test.backend), WithMaxCodeLength(test.shortest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stream bytes.Buffer
+			if err := e.Encode(&stream, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decodeAll(t, stream.Bytes()), data) {
+				t.Fatal("decoded data doesn't match")
+			}
+		})
+	}
+}
+
+func TestLimitedSymbolLengthsTooManySymbols(t *testing.T) {
+	histogram := make(map[uint32]int)
+	for symbol := uint32(0); symbol < 257; symbol++ {
+		histogram[symbol] = int(symbol) + 1
+	}
+	if _, err := LimitedSymbolLengths(histogram, 8); err == nil {
+		t.Fatal("257 symbols got codes of at most 8 bits")
+	}
+	if _, err := codeLengths(histogram, 512, 8); err == nil {
+		t.Fatal("codeLengths gave 257 symbols codes of at most 8 bits")
+	}
+	lengths, err := LimitedSymbolLengths(histogram, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range lengths {
+		if l.Length > 9 {
+			t.Fatalf("symbol %d has a %d bit code", l.Symbol, l.Length)
+		}
+	}
+}
@@ -4,6 +4,11 @@ go 1.17
 
 replace io.whypeople/huffman/common => ../common
 
-require io.whypeople/huffman/common v0.0.0-00010101000000-000000000000
+replace io.whypeople/huffman/decompress => ../decompress
+
+require (
+	io.whypeople/huffman/common v0.0.0-00010101000000-000000000000
+	io.whypeople/huffman/decompress v0.0.0-00010101000000-000000000000
+)
 
 require github.com/dropbox/godropbox v0.0.0-20200228041828-52ad444d3502 // indirect
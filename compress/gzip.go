@@ -0,0 +1,155 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"io.whypeople/huffman/common"
+)
+
+// GzipWriter is an io.WriteCloser that compresses everything written to it into a single gzip
+// member, which stock gzip tools can decompress. Data is buffered into blocks, and each block is
+// written as whichever kind of DEFLATE block is smallest.
+type GzipWriter struct {
+	w           io.Writer
+	buf         []byte
+	out         *bytes.Buffer     // Coded data that hasn't been written to w yet
+	bw          *common.BitWriter // Codes into out, holding the bits that don't make a whole word yet
+	digest      hash.Hash32       // The CRC-32 of all the data written so far
+	size        uint32            // The size of all the data written so far, modulo 2^32
+	wroteHeader bool
+	closed      bool
+	err         error
+}
+
+// NewGzipWriter returns a new GzipWriter, the caller must Close it to finish the member
+// w: The writer to write the gzip member to
+func NewGzipWriter(w io.Writer) *GzipWriter {
+	out := new(bytes.Buffer)
+	return &GzipWriter{
+		w:      w,
+		buf:    make([]byte, 0, common.STREAM_BLOCK_SIZE),
+		out:    out,
+		bw:     common.NewBitWriter(out),
+		digest: crc32.NewIEEE(),
+	}
+}
+
+// Reset discards the writer's state and makes it write a new member to w. Its buffers are kept,
+// so one writer can compress many inputs.
+// w: The writer to write the gzip member to
+func (z *GzipWriter) Reset(w io.Writer) {
+	z.w = w
+	z.buf = z.buf[:0]
+	z.out.Reset()
+	z.bw.Reset(z.out)
+	z.digest.Reset()
+	z.size = 0
+	z.wroteHeader, z.closed, z.err = false, false, nil
+}
+
+// CompressFileGzip compresses a file into a gzip member
+// infile: The file to be compressed
+// outfile: The file to write the gzip member to
+func CompressFileGzip(infile *os.File, outfile *os.File) (*os.File, error) {
+	// Make sure file pointers are valid
+	if infile == nil || outfile == nil {
+		return nil, errors.New("infile and outfile cannot be nil")
+	}
+
+	writer := NewGzipWriter(outfile)
+	if _, err := io.Copy(writer, infile); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return outfile, nil
+}
+
+// Write buffers p and compresses every block that fills up
+// p: The data to be compressed
+func (z *GzipWriter) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errors.New("write to closed writer")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		copied := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+copied]
+		p = p[copied:]
+		n += copied
+
+		if len(z.buf) == cap(z.buf) {
+			if err := z.writeBlock(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes the buffered data as the final block and ends the member, it does not close the
+// underlying writer
+func (z *GzipWriter) Close() error {
+	if z.closed {
+		return z.err
+	}
+	if err := z.writeBlock(true); err != nil {
+		return err
+	}
+	z.closed = true
+
+	// The last byte of the DEFLATE stream is padded with zeros
+	z.bw.Flush()
+	z.write(z.out.Bytes())
+	z.write(&common.GzipTrailer{DataChecksum: z.digest.Sum32(), Size: z.size})
+	return z.err
+}
+
+// writeBlock compresses the buffered data as a DEFLATE block and writes out every whole buffer of
+// coded bits
+// final: Whether this is the last block of the member
+func (z *GzipWriter) writeBlock(final bool) error {
+	if !z.wroteHeader {
+		z.wroteHeader = true
+		z.write(common.CreateGzipHeader())
+	}
+	if z.err != nil {
+		return z.err
+	}
+
+	z.digest.Write(z.buf)
+	z.size += uint32(len(z.buf))
+	if err := writeDeflateBlock(z.bw, z.buf, final); err != nil {
+		z.err = err
+		return err
+	}
+	z.buf = z.buf[:0]
+
+	z.write(z.out.Bytes())
+	z.out.Reset()
+	return z.err
+}
+
+// write writes raw bytes or fixed size data to the underlying writer, keeping the first error
+// data: The data to write
+func (z *GzipWriter) write(data interface{}) {
+	if z.err != nil {
+		return
+	}
+	if raw, ok := data.([]byte); ok {
+		_, z.err = z.w.Write(raw)
+	} else {
+		z.err = binary.Write(z.w, binary.LittleEndian, data)
+	}
+}
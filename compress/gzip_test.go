@@ -0,0 +1,84 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"io.whypeople/huffman/common"
+)
+
+// gzipMember compresses data into a gzip member with a GzipWriter
+// t: The test
+// data: The data to compress
+func gzipMember(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var member bytes.Buffer
+	z := NewGzipWriter(&member)
+	if _, err := z.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return member.Bytes()
+}
+
+func TestGzipWriterDecodesWithStandardLibrary(t *testing.T) {
+	text := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog, ", 2000))
+	noise := make([]byte, 100000)
+	rand.New(rand.NewSource(3)).Read(noise)
+	tests := []struct {
+		name      string
+		data      []byte
+		blockType uint8 // The type of the member's first DEFLATE block
+	}{
+		{"empty", nil, common.DEFLATE_FIXED},
+		{"short", []byte("abcabcabc"), common.DEFLATE_FIXED},
+		{"text", text, common.DEFLATE_DYNAMIC},
+		{"noise", noise, common.DEFLATE_STORED},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			member := gzipMember(t, test.data)
+			headerSize := binary.Size(common.CreateGzipHeader())
+			if blockType := member[headerSize] >> 1 & 3; blockType != test.blockType {
+				t.Fatalf("first block has type %d, expected %d", blockType, test.blockType)
+			}
+
+			r, err := gzip.NewReader(bytes.NewReader(member))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, test.data) {
+				t.Fatal("decoded data doesn't match")
+			}
+		})
+	}
+}
+
+func TestGzipWriterMultipleMembers(t *testing.T) {
+	first, second := randomBytes(70000, 4), []byte("a second member")
+	stream := append(gzipMember(t, first), gzipMember(t, second)...)
+
+	r, err := gzip.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, append(first, second...)) {
+		t.Fatal("decoded data doesn't match")
+	}
+}
@@ -1,12 +1,11 @@
 package compress
 
 import (
-	"encoding/binary"
+	"bytes"
+	"context"
 	"errors"
 	"io"
-	"math"
 	"os"
-	"sync/atomic"
 
 	"io.whypeople/huffman/common"
 )
@@ -14,208 +13,207 @@ import (
 // CompressFile returns a data type with information about the compressed file
 // infile: The file to be compressed
 // outfile: The file to write the compressed data to
+// maxGoroutines: The maximum number of goroutines to use
 func CompressFile(infile *os.File, outfile *os.File, maxGoroutines int) (*os.File, error) {
+	return compressFile(context.Background(), infile, outfile, maxGoroutines, NewWriter(outfile))
+}
 
-	// Make sure file pointers are valid
-	if infile == nil || outfile == nil {
-		return nil, errors.New("infile and outfile cannot be nil")
-	}
+// CompressFileAdaptive is like CompressFile but codes every block with an adaptive Huffman tree
+// infile: The file to be compressed
+// outfile: The file to write the compressed data to
+// maxGoroutines: The maximum number of goroutines to use
+func CompressFileAdaptive(infile *os.File, outfile *os.File, maxGoroutines int) (*os.File, error) {
+	return compressFile(context.Background(), infile, outfile, maxGoroutines, NewAdaptiveWriter(outfile))
+}
 
-	// Build Histogram
-	histogram, err := buildHistogram(infile, maxGoroutines)
+// CompressFileLZ77 is like CompressFile but replaces repeated data with LZ77 matches before huffman coding
+// infile: The file to be compressed
+// outfile: The file to write the compressed data to
+// maxGoroutines: The maximum number of goroutines to use
+// window: The furthest back a match can be, a power of two from MIN_LZ77_WINDOW to MAX_LZ77_WINDOW
+func CompressFileLZ77(infile *os.File, outfile *os.File, maxGoroutines int, window int) (*os.File, error) {
+	writer, err := NewLZ77Writer(outfile, window)
 	if err != nil {
 		return nil, err
 	}
-	
-	// Build Huffman Tree
-	huffTreeRoot := HistogramToHuffTree(histogram)
-
-	// Create Header and dump it to the output file
-	originalFileSize := common.GetFileSize(infile)
-	writeFileHeader(outfile, len(histogram), originalFileSize)
-
-	// If the root is null, that means the infile must've been empty
-	if huffTreeRoot == nil {
-		return outfile, nil
-	}
-
-	// Assign codes to each leaf (the nodes that represent bytes in infile) in the huffman tree
-	huffCodeTable := HuffTreeToCodeTable(huffTreeRoot)
+	return compressFile(context.Background(), infile, outfile, maxGoroutines, writer)
+}
 
-	// Dump the tree to the outfile
-	treeDump := CreateTreeDump(huffTreeRoot)
-	outfile.Write(treeDump)
+// CompressFileBWT is like CompressFile but Burrows-Wheeler transforms large blocks before huffman coding
+// infile: The file to be compressed
+// outfile: The file to write the compressed data to
+// maxGoroutines: The maximum number of goroutines to use
+func CompressFileBWT(infile *os.File, outfile *os.File, maxGoroutines int) (*os.File, error) {
+	return compressFile(context.Background(), infile, outfile, maxGoroutines, NewBWTWriter(outfile))
+}
 
-	// Perform compression
-	infile.Seek(0, 0)
-	return compress(infile, outfile, maxGoroutines, huffCodeTable)
+// CompressFileContext is like CompressFile but codes each byte with a tree picked by the byte before it
+// infile: The file to be compressed
+// outfile: The file to write the compressed data to
+// maxGoroutines: The maximum number of goroutines to use
+func CompressFileContext(infile *os.File, outfile *os.File, maxGoroutines int) (*os.File, error) {
+	return compressFile(context.Background(), infile, outfile, maxGoroutines, NewContextWriter(outfile))
 }
 
-// A data struct that will be used to help keep track of the order of compressed data
-type compressedBlock struct {
-	data  common.BitStack // A buffer that's easy to write to bit by bit
-	order int 						// The read order of the block
+// CompressContext is like CompressFile but stops every goroutine and returns ctx.Err() once ctx is done
+// ctx: The context that stops compression
+// infile: The file to be compressed
+// outfile: The file to write the compressed data to
+// maxGoroutines: The maximum number of goroutines to use
+func CompressContext(ctx context.Context, infile *os.File, outfile *os.File, maxGoroutines int) (*os.File, error) {
+	return compressFile(ctx, infile, outfile, maxGoroutines, NewWriter(outfile))
 }
 
-// compress takes a file and writes the compressed version to the output file
-// infile: the file to be compressed
-// outfile: the file to write the compressed data to
-// codeTable: the code table to use for compression
-func compress(infile *os.File, outfile *os.File, maxGoroutines int, codeTable HuffCodeTable) (*os.File, error) {
-	totalReadsNeeded := int(math.Ceil(float64(common.GetFileSize(infile)) / float64(common.MAX_IO_BLOCK_SIZE)))
-
-	// Create concurrent channels to handle errors and manage the order of compressed data
-	compressedChannels := make([]chan common.BitStack, 0, totalReadsNeeded)
-	errorChannel :=  make(chan error)
-	defer close(errorChannel)
-	defer func() {
-		for _, c := range compressedChannels {
-			close(c)
-		}
-	}()
-
-	// This value will keep track of the read/write order of the compressed data
-	order := int32(-1)
-
-	// Each goroutine will read from the file and compress it
-	compressJob := func() {
-		buf := make([]byte, common.MAX_IO_BLOCK_SIZE)
-		compressedBuffer := common.NewBitStack(common.MAX_BIT_BUFFER_SIZE)
-
-		// Read and compress data while not at the end of the file
-		for {
-			nbytes, err := infile.Read(buf);
-			if err == io.EOF {
-				break
-			}
-			// Check for any IO Errors
-			if err != nil {
-				errorChannel <- err
-				return
-			}
-
-			// Update read order val
-			readOrder := atomic.AddInt32(&order, 1)
-
-			// Compress the data read
-			for _, b := range buf[:nbytes] {
-				// Get the code for the byte
-				code := codeTable[b]
-				compressedBuffer.Append(code, 0)
-			}
-		
-			// Copy the compressed buffer to the channel, then reset it
-			compressedChannels[readOrder] <- compressedBuffer.Copy()
-			compressedBuffer.Reset()
-		}
-	}
+// compressFile compresses a file through a writer
+// ctx: The context that stops compression
+// infile: The file to be compressed
+// outfile: The file the writer writes to
+// maxGoroutines: The maximum number of goroutines to use
+// writer: The writer to compress the file with
+func compressFile(ctx context.Context, infile *os.File, outfile *os.File, maxGoroutines int, writer *Writer) (*os.File, error) {
 
-	// Create empty channels that hold the compressed data
-	for i := 0; i < totalReadsNeeded; i++ {
-		compressedChannels = append(compressedChannels, make(chan common.BitStack))
+	// Make sure file pointers are valid
+	if infile == nil || outfile == nil {
+		return nil, errors.New("infile and outfile cannot be nil")
 	}
 
-	// Start the workers
-	for i := 0; i < maxGoroutines; i++ {
-		go compressJob()
+	// Seekable files are split into blocks that are compressed concurrently
+	if start, err := infile.Seek(0, io.SeekCurrent); err == nil {
+		if err := compressParallel(ctx, infile, start, writer, maxGoroutines); err != nil {
+			return nil, err
+		}
+		return outfile, nil
 	}
 
-	// Merge compressed data from the workers into a single buffer
-	mergedOutBuffer := common.NewBitStack(common.MAX_BIT_BUFFER_SIZE)
-	select {
-	case err := <-errorChannel:
+	// Anything else is streamed through the writer
+	if _, err := io.Copy(writer, common.NewContextReader(ctx, infile)); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
 		return nil, err
+	}
+	return outfile, nil
+}
+
+// A compressed block that is ready to be written
+type encodedBlock struct {
+	data      []byte // The uncompressed data
+	header    *common.BlockHeader
+	treeDump  []byte
+	payload   []byte
+	histogram map[uint32]int // The number of times each symbol occurs, nil if the block can't be coded with an earlier block's tree
+	lengths   []uint8        // The code lengths the block is coded with, nil if later blocks can't reuse them
+}
+
+// encodeBlock compresses a single block the way the writer is set up to and creates its header,
+// it only reads the writer's settings so blocks can be encoded concurrently
+// data: The uncompressed data, must not be empty
+func (z *Writer) encodeBlock(data []byte) (*encodedBlock, error) {
+	var treeDump, payload []byte
+	var histogram map[uint32]int
+	var lengths []uint8
+	var err error
+	switch {
+	case z.adaptive:
+		payload = encodeAdaptive(data)
+	case z.window != 0:
+		treeDump, payload, err = compressLZ77Block(data, z.maxCodeLength, z.window)
+	case z.bwt:
+		treeDump, payload, err = compressBWTBlock(data, z.maxCodeLength)
+	case z.context:
+		treeDump, payload, err = compressContextBlock(data, z.maxCodeLength)
 	default:
-		for _, channel := range compressedChannels {
-			// Read the data from the channel
-			data := <-channel
-
-			// Merge data from the compressed channels
-			_, idx := mergedOutBuffer.Append(data, 0)
-			for idx < data.Size() {
-				if mergedOutBuffer.Size() >= common.MAX_BIT_BUFFER_SIZE {
-					// Write the data to the output file
-					_, err := outfile.Write(mergedOutBuffer.Vec().RawData())
-					if err != nil {
-						return nil, err
-					}
-					mergedOutBuffer.Reset()
-				}
-				_, idx = mergedOutBuffer.Append(data, idx)
-			}
+		histogram = make(map[uint32]int)
+		for _, b := range data {
+			histogram[uint32(b)]++
+		}
+		if lengths, err = codeLengths(histogram, common.ALPHABET_SIZE, z.maxCodeLength); err == nil {
+			treeDump, payload = CreateCodeLengthDump(lengths), encodeWithLengths(data, lengths)
 		}
 	}
-
-	// Write the remaining bits from the merged buffer to the outfile
-	writeAmount := int(math.Ceil(float64(mergedOutBuffer.Size()) / 8))
-	_, err := outfile.Write(mergedOutBuffer.Vec().RawData()[:writeAmount])
 	if err != nil {
 		return nil, err
 	}
-	return outfile, nil
+	header := common.CreateBlockHeader(uint32(len(data)), uint16(len(treeDump)), uint32(len(payload)))
+	return &encodedBlock{data, header, treeDump, payload, histogram, lengths}, nil
 }
 
-// writeFileHeader writes the compression header to the output file and returns the header struct
-// outfile: the output file to be compressed
-// uniqueSymbols: the number of unique symbols in the orignal file
-// originalFileSize: the size of the original file
-func writeFileHeader(outfile *os.File, uniqueSymbolsFromIn int, originalFileSize int64) *common.HuffHeader {
-	treeSize := (3 * uniqueSymbolsFromIn) - 1;
-	header := common.CreateHeader(uint16(treeSize), uint64(originalFileSize))
-	binary.Write(outfile, common.Endianess(), *header)
-	return header
+// encodeWithLengths huffman codes a block with the canonical code of a set of code lengths
+// block: The uncompressed data
+// lengths: The code length of every symbol, every symbol in the block must have a code
+func encodeWithLengths(block []byte, lengths []uint8) []byte {
+	// A block coded with a single symbol's code carries no information beyond its size
+	if len(common.SparseCodeLengths(lengths)) == 1 {
+		return nil
+	}
+	return encodeSymbols(block, CodeLengthsToCodeTable(lengths))
 }
 
-// buildHistogram builds a histogram from a file using the specified number of maxGoroutines
-// infile: The file to build the histogram from
-// maxGoroutines: The number of goroutines to use to build the histogram concurrently
-func buildHistogram(infile *os.File, maxGoroutines int) (map[byte]int, error) {
-	histogramChan := make(chan map[byte]int)
-	errChan := make(chan error)
-	defer close(histogramChan)
-	defer close(errChan)
-
-	// The job each go routine will do is to read a block from the file and increment their own histogram
-	countBytesJob := func() {
-		buf := make([]byte, common.MAX_IO_BLOCK_SIZE)
-		localHist := make(map[byte]int)
-		for {
-			n, err := infile.Read(buf)
-			if err == io.EOF {
-				// Done reading file, send local histogram down the channel
-				histogramChan <- localHist
-				break
-			}
-			if err != nil {
-				// Some IO Error, send it down the error channel
-				errChan <- err
-				return
-			}
-			// Build local histogram
-			for _, b := range buf[:n] {
-				localHist[b]++
-			}
+// reusedSize returns the size of the payload of a block coded with the code lengths of an earlier
+// block, the lengths can only be reused if they give every symbol in the block a code
+// histogram: The number of times each symbol in the block occurs
+// lengths: The code length of every symbol
+func reusedSize(histogram map[uint32]int, lengths []uint8) (int, bool) {
+	bits := 0
+	for symbol, count := range histogram {
+		if lengths[symbol] == 0 {
+			return 0, false
 		}
+		bits += count * int(lengths[symbol])
 	}
-
-	// Each goroutine will read from the file and build a local histogram
-	for i := 0; i < maxGoroutines; i++ {
-		go countBytesJob()
+	if len(common.SparseCodeLengths(lengths)) == 1 {
+		bits = 0
 	}
+	return (bits + 7) / 8, true
+}
 
-	select {
-	case err := <-errChan:
-		// Handle Error
+// codeLengths builds a Huffman Tree from a histogram and returns the length of every symbol's code.
+// Skewed histograms can make the tree too deep, in which case the lengths are limited.
+// Returns an error if the limit is too short to give every symbol a code.
+// histogram: The number of times each symbol occurs
+// alphabetSize: The number of symbols in the alphabet
+// maxCodeLength: The longest code allowed
+func codeLengths(histogram map[uint32]int, alphabetSize int, maxCodeLength int) ([]uint8, error) {
+	lengths, err := symbolCodeLengths(histogram, maxCodeLength)
+	if err != nil {
 		return nil, err
-	default:
-		// Recieve all the local histograms from the goroutines and compile them into 1 histogram
-		histogram := make(map[byte]int)
-		for i := 0; i < maxGoroutines; i++ {
-			hist := <- histogramChan
-			for k, v := range hist {
-				histogram[k] += v
-			}
+	}
+	return common.DenseCodeLengths(lengths, alphabetSize), nil
+}
+
+// symbolCodeLengths is like codeLengths but only returns the symbols in the histogram, in symbol order.
+// Writers check their code length limit against their alphabet when they're created, so a writer
+// should never see the error, but it's returned rather than crashing the program if it does.
+// histogram: The number of times each symbol occurs
+// maxCodeLength: The longest code allowed
+func symbolCodeLengths(histogram map[uint32]int, maxCodeLength int) ([]common.SymbolLength, error) {
+	lengths := HuffTreeToSymbolLengths(HistogramToHuffTree(histogram))
+	for _, l := range lengths {
+		if int(l.Length) > maxCodeLength {
+			return LimitedSymbolLengths(histogram, maxCodeLength)
 		}
-		return histogram, nil
 	}
-}
\ No newline at end of file
+	return lengths, nil
+}
+
+// encodeSymbols huffman codes data and returns the packed bits
+// data: The data to be encoded
+// codeTable: The code table to use for encoding
+func encodeSymbols(data []byte, codeTable HuffCodeTable) []byte {
+	out := new(bytes.Buffer)
+	bw := common.NewBitWriter(out)
+
+	for _, b := range data {
+		writeCode(bw, codeTable[uint32(b)])
+	}
+
+	bw.Flush()
+	return out.Bytes()
+}
+
+// writeCode writes a code to a bit writer
+// bw: The bit writer
+// code: The code to write
+func writeCode(bw *common.BitWriter, code HuffCode) {
+	bw.WriteBits(code.Bits, uint(code.Length))
+}
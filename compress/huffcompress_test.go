@@ -0,0 +1,89 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+
+	"io.whypeople/huffman/common"
+)
+
+// The number of times each input is compressed and compared
+const DETERMINISM_RUNS = 20
+
+// equalWeightInputs returns inputs where many symbols occur the same number of times, so every
+// tie in building the tree has to be broken the same way
+func equalWeightInputs() map[string][]byte {
+	inputs := make(map[string][]byte)
+
+	// Every byte the same number of times
+	var every []byte
+	for round := 0; round < 64; round++ {
+		for b := 0; b < common.ALPHABET_SIZE; b++ {
+			every = append(every, byte(b))
+		}
+	}
+	inputs["every byte"] = every
+
+	// Groups of bytes that share a weight, 1 for the first group, 2 for the next and so on
+	var groups []byte
+	for b := 0; b < common.ALPHABET_SIZE; b++ {
+		for i := 0; i <= b/16; i++ {
+			groups = append(groups, byte(b))
+		}
+	}
+	inputs["groups"] = groups
+
+	// Two symbols of equal weight
+	inputs["two"] = bytes.Repeat([]byte("ab"), 5000)
+	inputs["random"] = randomBytes(200000, 2)
+	return inputs
+}
+
+func TestCompressDeterministic(t *testing.T) {
+	backends := []string{BACKEND_HUFFMAN, BACKEND_ADAPTIVE, BACKEND_LZ77, BACKEND_BWT, BACKEND_CONTEXT, BACKEND_GZIP}
+	for name, data := range equalWeightInputs() {
+		for _, backend := range backends {
+			e, err := NewEncoder(WithBackend(backend))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []byte
+			for run := 0; run < DETERMINISM_RUNS; run++ {
+				var stream bytes.Buffer
+				if err := e.Encode(&stream, bytes.NewReader(data)); err != nil {
+					t.Fatal(err)
+				}
+				if run == 0 {
+					want = stream.Bytes()
+				} else if !bytes.Equal(stream.Bytes(), want) {
+					t.Fatalf("%s with %s: run %d wrote different bytes", name, backend, run)
+				}
+			}
+
+			// The same bytes every run are no use if they're the wrong bytes
+			if !bytes.Equal(decodeAll(t, want), data) {
+				t.Fatalf("%s with %s: the stream decoded to different data", name, backend)
+			}
+		}
+	}
+}
+
+func TestCodeLengthsDeterministic(t *testing.T) {
+	// One heavy symbol pushes the rest deep enough that a limit of 9 bits needs package-merge
+	histogram := make(map[uint32]int)
+	for symbol := uint32(0); symbol < common.ALPHABET_SIZE; symbol++ {
+		histogram[symbol] = 1 + int(symbol)%3
+	}
+	histogram[0] = 1 << 20
+	for _, limit := range []int{9, common.MAX_CODE_SIZE} {
+		want, err := codeLengths(histogram, common.ALPHABET_SIZE, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for run := 0; run < DETERMINISM_RUNS; run++ {
+			if got, _ := codeLengths(histogram, common.ALPHABET_SIZE, limit); !bytes.Equal(got, want) {
+				t.Fatalf("limit %d: run %d gave different code lengths", limit, run)
+			}
+		}
+	}
+}
@@ -15,19 +15,29 @@ type HuffMinHeap interface {
 	Size() int
 }
 
+// A node in the heap along with its place in the tie-break order
+type heapItem struct {
+	node  common.HuffNode
+	order int64 // Leaves are ordered by symbol, joined nodes come after every leaf in the order they were made
+}
+
 // The implementation of the interface for container/heap via slices
-type huffNodeHeap []common.HuffNode
+type huffNodeHeap []heapItem
 
 // Len returns the length of the heap
 func (h huffNodeHeap) Len() int {
 	return len(h)
 }
 
-// Less returns true if the first node is less than the second node
+// Less returns true if the first node is less than the second node. Equal weights are broken by
+// the tie-break order, so the tree doesn't depend on the order nodes were inserted in.
 // i: The index of the first node
 // j: The index of the second node
 func (h huffNodeHeap) Less(i, j int) bool {
-	return h[i].Data().Weight < h[j].Data().Weight
+	if h[i].node.Data().Weight != h[j].node.Data().Weight {
+		return h[i].node.Data().Weight < h[j].node.Data().Weight
+	}
+	return h[i].order < h[j].order
 }
 
 // Swap two nodes in the heap
@@ -37,7 +47,7 @@ func (h huffNodeHeap) Swap(i, j int) {
 
 // Pushes a node onto the heap
 func (h *huffNodeHeap) Push(x interface{}) {
-	*h = append(*h, x.(common.HuffNode))
+	*h = append(*h, x.(heapItem))
 }
 
 // Pop returns the minimum node from the heap
@@ -50,18 +60,24 @@ func (h *huffNodeHeap) Pop() interface{} {
 
 // Internal Data Struct for heap operations
 type huffMinHeap struct {
-	heap *huffNodeHeap
+	heap   *huffNodeHeap
+	joined int64 // The number of joined nodes inserted so far
 }
 
 // Insert a node into the heap
 // n: The node to be inserted
 func (h *huffMinHeap) Insert(n common.HuffNode) {
-	heap.Push(h.heap, n)
+	order := int64(n.Data().Symbol)
+	if !n.IsLeaf() {
+		order = 1<<32 + h.joined
+		h.joined++
+	}
+	heap.Push(h.heap, heapItem{n, order})
 }
 
 // ExtractMin returns the minimum node from the heap
 func (h *huffMinHeap) ExtractMin() common.HuffNode {
-	return heap.Pop(h.heap).(common.HuffNode)
+	return heap.Pop(h.heap).(heapItem).node
 }
 
 // Size returns the size of the heap
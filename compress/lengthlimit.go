@@ -0,0 +1,128 @@
+package compress
+
+import (
+	"fmt"
+	"sort"
+
+	"io.whypeople/huffman/common"
+)
+
+// An item in the package-merge algorithm, either a single symbol or a package of two items
+type packageItem struct {
+	weight int
+	symbol int64 // The symbol of a leaf item, -1 for packages
+	left   *packageItem
+	right  *packageItem
+}
+
+// LimitedCodeLengths returns optimal code lengths for a histogram where no code is longer than
+// maxCodeLength, using the package-merge algorithm. Returns an error if the histogram has more
+// than 2^maxCodeLength symbols, which can't all get a code that short.
+// histogram: The number of times each symbol occurs
+// alphabetSize: The number of symbols in the alphabet
+// maxCodeLength: The longest code length allowed
+func LimitedCodeLengths(histogram map[uint32]int, alphabetSize int, maxCodeLength int) ([]uint8, error) {
+	lengths, err := LimitedSymbolLengths(histogram, maxCodeLength)
+	if err != nil {
+		return nil, err
+	}
+	return common.DenseCodeLengths(lengths, alphabetSize), nil
+}
+
+// LimitedSymbolLengths is like LimitedCodeLengths but only returns the symbols in the histogram,
+// in symbol order
+// histogram: The number of times each symbol occurs
+// maxCodeLength: The longest code length allowed
+func LimitedSymbolLengths(histogram map[uint32]int, maxCodeLength int) ([]common.SymbolLength, error) {
+	if maxCodeLength < minCodeLength(len(histogram)) {
+		return nil, fmt.Errorf("%d symbols can't all have codes of at most %d bits", len(histogram), maxCodeLength)
+	}
+
+	// Sort the symbols by weight, ties are broken by symbol so the result is deterministic
+	leaves := make([]*packageItem, 0, len(histogram))
+	for symbol, weight := range histogram {
+		leaves = append(leaves, &packageItem{weight: weight, symbol: int64(symbol)})
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		if leaves[i].weight != leaves[j].weight {
+			return leaves[i].weight < leaves[j].weight
+		}
+		return leaves[i].symbol < leaves[j].symbol
+	})
+
+	// A single symbol still needs a code of 1 bit
+	if len(leaves) == 1 {
+		return []common.SymbolLength{{Symbol: uint32(leaves[0].symbol), Length: 1}}, nil
+	}
+
+	// Each level packages up pairs of the level below and merges them back in with the leaves
+	items := leaves
+	for level := 1; level < maxCodeLength; level++ {
+		packages := make([]*packageItem, 0, len(items)/2)
+		for i := 0; i+1 < len(items); i += 2 {
+			packages = append(packages, &packageItem{
+				weight: items[i].weight + items[i+1].weight,
+				symbol: -1,
+				left:   items[i],
+				right:  items[i+1],
+			})
+		}
+		items = mergeItems(leaves, packages)
+	}
+
+	// Every time a symbol appears in the cheapest 2n-2 items its code gets 1 bit longer
+	depths := make(map[int64]uint8, len(leaves))
+	for _, item := range items[:2*len(leaves)-2] {
+		countLeaves(item, depths)
+	}
+	lengths := make([]common.SymbolLength, 0, len(leaves))
+	for symbol, length := range depths {
+		lengths = append(lengths, common.SymbolLength{Symbol: uint32(symbol), Length: length})
+	}
+	sort.Slice(lengths, func(i, j int) bool {
+		return lengths[i].Symbol < lengths[j].Symbol
+	})
+	return lengths, nil
+}
+
+// minCodeLength returns the shortest code length limit that still gives every symbol a code,
+// ceil(log2(symbols))
+// symbols: The number of symbols that need a code
+func minCodeLength(symbols int) int {
+	length := 0
+	for 1<<length < symbols {
+		length++
+	}
+	return length
+}
+
+// mergeItems merges two lists of items that are sorted by weight, leaves come first on ties
+// leaves: The sorted leaf items
+// packages: The sorted packages
+func mergeItems(leaves []*packageItem, packages []*packageItem) []*packageItem {
+	merged := make([]*packageItem, 0, len(leaves)+len(packages))
+	i, j := 0, 0
+	for i < len(leaves) && j < len(packages) {
+		if leaves[i].weight <= packages[j].weight {
+			merged = append(merged, leaves[i])
+			i++
+		} else {
+			merged = append(merged, packages[j])
+			j++
+		}
+	}
+	merged = append(merged, leaves[i:]...)
+	return append(merged, packages[j:]...)
+}
+
+// countLeaves adds 1 to the code length of every symbol in an item
+// item: The item to count
+// lengths: The code length of every symbol so far
+func countLeaves(item *packageItem, lengths map[int64]uint8) {
+	if item.symbol >= 0 {
+		lengths[item.symbol]++
+		return
+	}
+	countLeaves(item.left, lengths)
+	countLeaves(item.right, lengths)
+}
@@ -0,0 +1,214 @@
+package compress
+
+import (
+	"bytes"
+
+	"io.whypeople/huffman/common"
+)
+
+const LZ77_HASH_BITS = 15  // The number of bits in the hash of a match's first bytes.
+const LZ77_MAX_CHAIN = 128 // The most earlier positions compared when looking for a match.
+
+// An LZ77 token, either a literal byte or a copy of earlier data in the block
+type lzToken struct {
+	length uint16 // The match length, 0 for a literal
+	value  uint16 // The literal byte, or the match distance
+}
+
+// matchFinder finds matches in a block with hash chains, every position is chained to the last
+// position before it whose first bytes had the same hash
+type matchFinder struct {
+	data   []byte
+	window int     // The furthest back a match can be, a power of two
+	head   []int32 // The last position with each hash, -1 if there isn't one
+	prev   []int32 // The position before each position with the same hash, indexed modulo window
+}
+
+// newMatchFinder returns a matchFinder for a block
+// data: The block to find matches in
+// window: The furthest back a match can be, a power of two
+func newMatchFinder(data []byte, window int) *matchFinder {
+	m := &matchFinder{
+		data:   data,
+		window: window,
+		head:   make([]int32, 1<<LZ77_HASH_BITS),
+		prev:   make([]int32, window),
+	}
+	for i := range m.head {
+		m.head[i] = -1
+	}
+	return m
+}
+
+// hash returns the hash of the first MIN_MATCH_LENGTH bytes at a position
+// pos: The position to hash
+func (m *matchFinder) hash(pos int) uint32 {
+	v := uint32(m.data[pos]) | uint32(m.data[pos+1])<<8 | uint32(m.data[pos+2])<<16
+	return v * 2654435761 >> (32 - LZ77_HASH_BITS)
+}
+
+// insert adds a position to its hash chain
+// pos: The position to insert
+func (m *matchFinder) insert(pos int) {
+	if pos+common.MIN_MATCH_LENGTH > len(m.data) {
+		return
+	}
+	h := m.hash(pos)
+	m.prev[pos&(m.window-1)] = m.head[h]
+	m.head[h] = int32(pos)
+}
+
+// longestMatch returns the length and distance of the longest match for a position, the length
+// is 0 if there's no match of at least MIN_MATCH_LENGTH bytes
+// pos: The position to find a match for, it must not have been inserted yet
+func (m *matchFinder) longestMatch(pos int) (int, int) {
+	if pos+common.MIN_MATCH_LENGTH > len(m.data) {
+		return 0, 0
+	}
+	limit := len(m.data) - pos
+	if limit > common.MAX_MATCH_LENGTH {
+		limit = common.MAX_MATCH_LENGTH
+	}
+
+	bestLength, bestDistance := 0, 0
+	candidate := int(m.head[m.hash(pos)])
+	for chain := 0; chain < LZ77_MAX_CHAIN && candidate >= 0 && pos-candidate <= m.window; chain++ {
+		// Only compare the whole match if it could beat the best one so far
+		if m.data[candidate+bestLength] == m.data[pos+bestLength] {
+			length := 0
+			for length < limit && m.data[candidate+length] == m.data[pos+length] {
+				length++
+			}
+			if length > bestLength {
+				bestLength, bestDistance = length, pos-candidate
+				if length == limit {
+					break
+				}
+			}
+		}
+		candidate = int(m.prev[candidate&(m.window-1)])
+	}
+
+	if bestLength < common.MIN_MATCH_LENGTH {
+		return 0, 0
+	}
+	return bestLength, bestDistance
+}
+
+// findMatches splits a block into literals and matches. A match is put off by one byte when
+// the next position has a longer one.
+// data: The block to split
+// window: The furthest back a match can be, a power of two
+func findMatches(data []byte, window int) []lzToken {
+	m := newMatchFinder(data, window)
+	tokens := make([]lzToken, 0, len(data)/2)
+
+	length, distance := m.longestMatch(0)
+	for pos := 0; pos < len(data); {
+		m.insert(pos)
+		nextLength, nextDistance := m.longestMatch(pos + 1)
+
+		if length == 0 || nextLength > length {
+			tokens = append(tokens, lzToken{0, uint16(data[pos])})
+			pos++
+			length, distance = nextLength, nextDistance
+			continue
+		}
+
+		tokens = append(tokens, lzToken{uint16(length), uint16(distance)})
+		for i := 1; i < length; i++ {
+			m.insert(pos + i)
+		}
+		pos += length
+		length, distance = m.longestMatch(pos)
+	}
+	return tokens
+}
+
+// compressLZ77Block LZ77 codes a block and huffman codes the result, returning the code length
+// dumps of the literal/length and distance alphabets, one after the other, and the payload
+// block: The uncompressed data, must not be empty
+// maxCodeLength: The longest code the block may use
+// window: The furthest back a match can be, a power of two
+func compressLZ77Block(block []byte, maxCodeLength int, window int) ([]byte, []byte, error) {
+	tokens := findMatches(block, window)
+	literals, distances := countTokens(tokens)
+
+	// A block without matches still stores a distance code so the dump isn't empty
+	if len(distances) == 0 {
+		distances[0] = 1
+	}
+
+	literalLengths, err := codeLengths(literals, common.LITERAL_LENGTH_ALPHABET_SIZE, maxCodeLength)
+	if err != nil {
+		return nil, nil, err
+	}
+	distanceLengths, err := codeLengths(distances, common.DISTANCE_ALPHABET_SIZE, maxCodeLength)
+	if err != nil {
+		return nil, nil, err
+	}
+	dump := append(CreateCodeLengthDump(literalLengths), CreateCodeLengthDump(distanceLengths)...)
+
+	// A lone distance symbol's code carries no information, so it's never written
+	distanceCodes := CodeLengthsToCodeTable(distanceLengths)
+	if len(distances) == 1 {
+		distanceCodes = nil
+	}
+	return dump, encodeTokens(tokens, CodeLengthsToCodeTable(literalLengths), distanceCodes), nil
+}
+
+// countTokens returns the histograms of the literal/length and distance alphabets for a list of
+// tokens, the end of block symbol is counted once
+// tokens: The tokens to count
+func countTokens(tokens []lzToken) (map[uint32]int, map[uint32]int) {
+	literals := map[uint32]int{common.END_OF_BLOCK: 1}
+	distances := make(map[uint32]int)
+	for _, t := range tokens {
+		if t.length == 0 {
+			literals[uint32(t.value)]++
+		} else {
+			literals[common.LengthSymbol(int(t.length))]++
+			distances[common.DistanceSymbol(int(t.value))]++
+		}
+	}
+	return literals, distances
+}
+
+// encodeTokens huffman codes LZ77 tokens, followed by the end of block symbol, and returns the
+// packed bits
+// tokens: The tokens to be encoded
+// literalCodes: The code table of the literal/length alphabet
+// distanceCodes: The code table of the distance alphabet, nil if distance codes aren't written
+func encodeTokens(tokens []lzToken, literalCodes HuffCodeTable, distanceCodes HuffCodeTable) []byte {
+	out := new(bytes.Buffer)
+	bw := common.NewBitWriter(out)
+	writeTokens(bw, tokens, literalCodes, distanceCodes)
+	bw.Flush()
+	return out.Bytes()
+}
+
+// writeTokens writes the codes of LZ77 tokens, followed by the end of block symbol
+// bw: The bit writer
+// tokens: The tokens to be encoded
+// literalCodes: The code table of the literal/length alphabet
+// distanceCodes: The code table of the distance alphabet, nil if distance codes aren't written
+func writeTokens(bw *common.BitWriter, tokens []lzToken, literalCodes HuffCodeTable, distanceCodes HuffCodeTable) {
+	for _, t := range tokens {
+		if t.length == 0 {
+			writeCode(bw, literalCodes[uint32(t.value)])
+			continue
+		}
+
+		symbol := common.LengthSymbol(int(t.length))
+		index := symbol - common.FIRST_LENGTH_SYMBOL
+		writeCode(bw, literalCodes[symbol])
+		bw.WriteBits(uint64(t.length-common.LengthBase[index]), uint(common.LengthExtraBits[index]))
+
+		symbol = common.DistanceSymbol(int(t.value))
+		if distanceCodes != nil {
+			writeCode(bw, distanceCodes[symbol])
+		}
+		bw.WriteBits(uint64(t.value-common.DistanceBase[symbol]), uint(common.DistanceExtraBits[symbol]))
+	}
+	writeCode(bw, literalCodes[common.END_OF_BLOCK])
+}
@@ -0,0 +1,89 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"io.whypeople/huffman/common"
+)
+
+// encodeWith compresses data with an Encoder, and fails the test if it doesn't decode back to the data.
+// Returns the compressed stream
+// t: The test
+// data: The data to compress
+// opts: The options of the Encoder
+func encodeWith(t *testing.T, data []byte, opts ...Option) []byte {
+	t.Helper()
+	e, err := NewEncoder(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream bytes.Buffer
+	if err := e.Encode(&stream, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decodeAll(t, stream.Bytes()), data) {
+		t.Fatal("the stream decoded to different data")
+	}
+	return stream.Bytes()
+}
+
+func TestLZ77RoundTrip(t *testing.T) {
+	for _, input := range varietyInputs() {
+		for _, window := range []int{common.MIN_LZ77_WINDOW, 4096, common.MAX_LZ77_WINDOW} {
+			t.Run(fmt.Sprintf("%s window %d", input.name, window), func(t *testing.T) {
+				encodeWith(t, input.data, WithBackend(BACKEND_LZ77), WithLZ77Window(window))
+			})
+		}
+	}
+}
+
+func TestLZ77Matches(t *testing.T) {
+	for _, input := range varietyInputs() {
+		for _, window := range []int{common.MIN_LZ77_WINDOW, common.MAX_LZ77_WINDOW} {
+			block := input.data
+			if len(block) > common.STREAM_BLOCK_SIZE {
+				block = block[:common.STREAM_BLOCK_SIZE]
+			}
+
+			// Replaying the tokens rebuilds the block, and every match fits the window and alphabet
+			var replayed []byte
+			for _, token := range findMatches(block, window) {
+				if token.length == 0 {
+					replayed = append(replayed, byte(token.value))
+					continue
+				}
+				length, distance := int(token.length), int(token.value)
+				if length < common.MIN_MATCH_LENGTH || length > common.MAX_MATCH_LENGTH || distance < 1 || distance > window || distance > len(replayed) {
+					t.Fatalf("%s: match of %d bytes at distance %d with a window of %d", input.name, length, distance, window)
+				}
+				for i := 0; i < length; i++ {
+					replayed = append(replayed, replayed[len(replayed)-distance])
+				}
+			}
+			if !bytes.Equal(replayed, block) {
+				t.Fatalf("%s: the tokens of a window of %d rebuild different data", input.name, window)
+			}
+		}
+	}
+}
+
+func TestLZ77BeatsHuffmanOnRepeats(t *testing.T) {
+	for _, input := range varietyInputs() {
+		huffman := encodeWith(t, input.data)
+		lz77 := encodeWith(t, input.data, WithBackend(BACKEND_LZ77))
+		switch input.name {
+		case "short period", "long period", "long runs":
+			// Repeats within the window are matches, which huffman coding alone can't shrink
+			if 4*len(lz77) > len(huffman) {
+				t.Errorf("%s: lz77 compressed to %d bytes, huffman to %d", input.name, len(lz77), len(huffman))
+			}
+		case "random":
+			// Random data is stored either way
+			if len(lz77) != len(huffman) {
+				t.Errorf("%s: lz77 compressed to %d bytes, huffman to %d", input.name, len(lz77), len(huffman))
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package compress
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+)
+
+// The result of compressing one block, a nil block and error mark the end of the file
+type compressedResult struct {
+	block *encodedBlock
+	err   error
+}
+
+// A block for a worker to compress
+type compressJob struct {
+	number int                   // The position of the block in the file
+	result chan compressedResult // Where to send the compressed block
+}
+
+// compressParallel compresses a file on maxGoroutines workers that each read whole blocks with
+// ReadAt. Blocks are written in file order, so the output is the same for any number of workers.
+// Every goroutine has stopped by the time it returns, even if ctx is done or a block fails.
+// ctx: Stops the workers and returns ctx.Err() once it's done
+// infile: The file to be compressed
+// start: The offset in infile to start compressing from
+// writer: The writer to write the compressed blocks through
+// maxGoroutines: The maximum number of goroutines to use
+func compressParallel(ctx context.Context, infile *os.File, start int64, writer *Writer, maxGoroutines int) error {
+	// The result channels are queued up in file order. The queue only holds 2 * maxGoroutines
+	// channels, which limits the number of blocks in flight.
+	jobs := make(chan compressJob)
+	order := make(chan chan compressedResult, 2*maxGoroutines)
+
+	// Returning cancels the goroutines and waits for them
+	ctx, cancel := context.WithCancel(ctx)
+	var running sync.WaitGroup
+	defer running.Wait()
+	defer cancel()
+
+	// Hand out the blocks in order until the writer has seen the end of the file
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer close(jobs)
+		defer close(order)
+		for number := 0; ; number++ {
+			result := make(chan compressedResult, 1)
+			select {
+			case order <- result:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- compressJob{number, result}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Each worker reads a whole block at its own offset and compresses it
+	worker := func() {
+		defer running.Done()
+		for job := range jobs {
+			if err := ctx.Err(); err != nil {
+				job.result <- compressedResult{err: err}
+				continue
+			}
+
+			// Blocks are as big as the writer's buffer, and keep their data until they're
+			// written, so every block in flight gets its own buffer
+			data, _ := writer.blocks.Get().([]byte)
+			if cap(data) != cap(writer.buf) {
+				data = make([]byte, cap(writer.buf))
+			}
+			data = data[:cap(data)]
+			offset := start + int64(job.number)*int64(len(data))
+			nbytes, err := infile.ReadAt(data, offset)
+			if err != nil && err != io.EOF {
+				job.result <- compressedResult{err: err}
+				continue
+			}
+			if nbytes == 0 {
+				job.result <- compressedResult{}
+				continue
+			}
+			block, err := writer.encodeBlock(data[:nbytes])
+			job.result <- compressedResult{block: block, err: err}
+		}
+	}
+	running.Add(maxGoroutines)
+	for i := 0; i < maxGoroutines; i++ {
+		go worker()
+	}
+
+	// Write the blocks in file order until the end of the file. The queue is only closed early
+	// if ctx is done.
+	for result := range order {
+		var compressed compressedResult
+		select {
+		case compressed = <-result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if compressed.err != nil {
+			return compressed.err
+		}
+		if compressed.block == nil {
+			break
+		}
+		if err := writer.writeEncodedBlock(compressed.block); err != nil {
+			return err
+		}
+		writer.blocks.Put(compressed.block.data)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return writer.Close()
+}
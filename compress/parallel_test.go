@@ -0,0 +1,83 @@
+package compress
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"io.whypeople/huffman/common"
+	"io.whypeople/huffman/decompress"
+)
+
+// compressWith compresses a file with CompressFile and returns the stream
+// t: The test
+// path: The path of the file to compress
+// maxGoroutines: The maximum number of goroutines to use
+func compressWith(t *testing.T, path string, maxGoroutines int) []byte {
+	t.Helper()
+	infile, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer infile.Close()
+	outfile, err := os.CreateTemp(t.TempDir(), "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outfile.Close()
+
+	if _, err := CompressFile(infile, outfile, maxGoroutines); err != nil {
+		t.Fatalf("%d goroutines: %v", maxGoroutines, err)
+	}
+	stream, err := os.ReadFile(outfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+func TestCompressFileGoroutines(t *testing.T) {
+	// More blocks than the most goroutines, with a short last block
+	data := randomBytes(80*common.STREAM_BLOCK_SIZE+12345, 1)
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	want := compressWith(t, path, 1)
+	if !bytes.Equal(decodeAll(t, want), data) {
+		t.Fatal("decoded data doesn't match")
+	}
+	for _, g := range []int{2, 3, 4, 7, 8, 16, 33, 64} {
+		if got := compressWith(t, path, g); !bytes.Equal(got, want) {
+			t.Fatalf("%d goroutines wrote different output than 1", g)
+		}
+	}
+
+	// The block index lets the stream be decoded in parallel too
+	stream := filepath.Join(t.TempDir(), "stream")
+	if err := os.WriteFile(stream, want, 0600); err != nil {
+		t.Fatal(err)
+	}
+	infile, err := os.Open(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer infile.Close()
+	outfile, err := os.CreateTemp(t.TempDir(), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outfile.Close()
+	if _, err := decompress.DecompressFile(infile, outfile, 8); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := os.ReadFile(outfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Fatal("data decoded in parallel doesn't match")
+	}
+}
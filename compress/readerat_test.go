@@ -0,0 +1,155 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"io.whypeople/huffman/common"
+	"io.whypeople/huffman/decompress"
+)
+
+// seekableStream returns data that compresses to every kind of block, and the stream a writer with
+// a seek interval of MIN_BLOCK_SIZE compresses it to
+// t: The test
+func seekableStream(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	var data []byte
+
+	// Blocks of the same text reuse the first one's tree, random bytes are stored
+	text := strings.Repeat("every block starts with a fresh decoder, ", common.MIN_BLOCK_SIZE)
+	data = append(data, text[:4*common.MIN_BLOCK_SIZE]...)
+	uniform := make([]byte, 2*common.MIN_BLOCK_SIZE)
+	rand.New(rand.NewSource(3)).Read(uniform)
+	data = append(data, uniform...)
+	data = append(data, randomBytes(3*common.MIN_BLOCK_SIZE+100, 4)...)
+
+	var stream bytes.Buffer
+	z := NewWriter(&stream)
+	if err := z.SetSeekInterval(common.MIN_BLOCK_SIZE); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := z.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return data, stream.Bytes()
+}
+
+// newReaderAt returns a ReaderAt for a stream
+// t: The test
+// stream: The compressed stream
+func newReaderAt(t *testing.T, stream []byte) *decompress.ReaderAt {
+	t.Helper()
+	z, err := decompress.NewReaderAt(bytes.NewReader(stream), int64(len(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+func TestReaderAtBlockTypes(t *testing.T) {
+	data, stream := seekableStream(t)
+	info, err := decompress.Inspect(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Blocks != (len(data)+common.MIN_BLOCK_SIZE-1)/common.MIN_BLOCK_SIZE {
+		t.Fatalf("%d bytes were written as %d blocks", len(data), info.Blocks)
+	}
+	if info.ReusedTrees == 0 || info.StoredBlocks == 0 {
+		t.Fatalf("the stream has %d reused trees and %d stored blocks, it should have both", info.ReusedTrees, info.StoredBlocks)
+	}
+	if z := newReaderAt(t, stream); z.Size() != int64(len(data)) {
+		t.Fatalf("Size returned %d, expected %d", z.Size(), len(data))
+	}
+}
+
+func TestReaderAtRanges(t *testing.T) {
+	data, stream := seekableStream(t)
+	z := newReaderAt(t, stream)
+
+	// Ranges around every block boundary, ranges covering several blocks and the whole data
+	type span struct{ off, n int }
+	spans := []span{{0, len(data)}, {1, 3 * common.MIN_BLOCK_SIZE}, {common.MIN_BLOCK_SIZE / 2, 5 * common.MIN_BLOCK_SIZE}}
+	for boundary := common.MIN_BLOCK_SIZE; boundary < len(data); boundary += common.MIN_BLOCK_SIZE {
+		spans = append(spans, span{boundary - 7, 14}, span{boundary - 1, 1}, span{boundary, 1})
+		if boundary+common.MIN_BLOCK_SIZE < len(data) {
+			spans = append(spans, span{boundary - 1, common.MIN_BLOCK_SIZE + 2})
+		}
+	}
+	for _, s := range spans {
+		p := make([]byte, s.n)
+		n, err := z.ReadAt(p, int64(s.off))
+		if err != nil || n != s.n {
+			t.Fatalf("ReadAt(%d, %d) returned %d, %v", s.off, s.n, n, err)
+		}
+		if !bytes.Equal(p, data[s.off:s.off+s.n]) {
+			t.Fatalf("ReadAt(%d, %d) returned different data", s.off, s.n)
+		}
+	}
+}
+
+func TestReaderAtEnd(t *testing.T) {
+	data, stream := seekableStream(t)
+	z := newReaderAt(t, stream)
+	size := len(data)
+	p := make([]byte, 10)
+
+	// A read that ends exactly at the end of the data is whole
+	if n, err := z.ReadAt(p, int64(size-len(p))); n != len(p) || (err != nil && err != io.EOF) {
+		t.Fatalf("reading the last %d bytes returned %d, %v", len(p), n, err)
+	}
+	if !bytes.Equal(p, data[size-len(p):]) {
+		t.Fatal("the last bytes were read wrong")
+	}
+
+	// A read that runs past the end returns what there is
+	if n, err := z.ReadAt(p, int64(size-4)); n != 4 || err != io.EOF {
+		t.Fatalf("reading past the end returned %d, %v", n, err)
+	}
+	if !bytes.Equal(p[:4], data[size-4:]) {
+		t.Fatal("the bytes before the end were read wrong")
+	}
+
+	for _, off := range []int{size, size + 1, size + 10*common.MIN_BLOCK_SIZE} {
+		if n, err := z.ReadAt(p, int64(off)); n != 0 || err != io.EOF {
+			t.Fatalf("reading at %d of %d bytes returned %d, %v", off, size, n, err)
+		}
+	}
+	if _, err := z.ReadAt(p, -1); err == nil {
+		t.Fatal("reading at a negative offset succeeded")
+	}
+}
+
+func TestReaderAtWithoutIndex(t *testing.T) {
+	_, stream := seekableStream(t)
+
+	// The index flag is in the byte after the magic number and version
+	unindexed := append([]byte{}, stream...)
+	unindexed[5] &^= common.FLAG_BLOCK_INDEX
+	if _, err := decompress.NewReaderAt(bytes.NewReader(unindexed), int64(len(unindexed))); err == nil {
+		t.Fatal("a stream without a block index was opened")
+	}
+
+	var gzipped bytes.Buffer
+	z := NewGzipWriter(&gzipped)
+	z.Write([]byte("gzip members have no block index"))
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decompress.NewReaderAt(bytes.NewReader(gzipped.Bytes()), int64(gzipped.Len())); err == nil {
+		t.Fatal("a gzip member was opened")
+	}
+
+	// Cutting off the end of the index footer leaves a footer that isn't one
+	truncated := stream[:len(stream)-1]
+	if _, err := decompress.NewReaderAt(bytes.NewReader(truncated), int64(len(truncated))); !errors.Is(err, decompress.ErrBadMagic) {
+		t.Fatalf("a stream without its last byte opened with %v, expected a bad magic number", err)
+	}
+}
@@ -0,0 +1,122 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"io.whypeople/huffman/common"
+)
+
+// SymbolWriter huffman compresses a stream of symbols from an alphabet of any size up to 2^32,
+// such as 16 bit samples or the output of another transform. Symbols are buffered into blocks of
+// SYMBOL_BLOCK_SIZE, and each block stores the code lengths of only the symbols it uses.
+type SymbolWriter struct {
+	z   *Writer
+	buf []uint32
+}
+
+// NewSymbolWriter returns a new SymbolWriter, the caller must Close it to flush the final block
+// w: The writer to write the compressed stream to
+// alphabetSize: The number of symbols in the alphabet, every symbol written must be smaller
+func NewSymbolWriter(w io.Writer, alphabetSize uint32) (*SymbolWriter, error) {
+	if alphabetSize == 0 {
+		return nil, errors.New("alphabet size must be at least 1")
+	}
+	z := NewWriter(w)
+	z.alphabetSize = alphabetSize
+	return &SymbolWriter{z: z, buf: make([]uint32, 0, common.SYMBOL_BLOCK_SIZE)}, nil
+}
+
+// WriteSymbols buffers symbols and compresses every block that fills up
+// symbols: The symbols to be compressed
+func (s *SymbolWriter) WriteSymbols(symbols []uint32) error {
+	if s.z.err != nil {
+		return s.z.err
+	}
+	if s.z.closed {
+		return errors.New("write to closed writer")
+	}
+	for _, symbol := range symbols {
+		if symbol >= s.z.alphabetSize {
+			return fmt.Errorf("symbol %d is outside the alphabet of %d symbols", symbol, s.z.alphabetSize)
+		}
+	}
+
+	for len(symbols) > 0 {
+		copied := copy(s.buf[len(s.buf):cap(s.buf)], symbols)
+		s.buf = s.buf[:len(s.buf)+copied]
+		symbols = symbols[copied:]
+
+		if len(s.buf) == cap(s.buf) {
+			if err := s.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush compresses any buffered symbols as a (possibly short) block and writes it out
+func (s *SymbolWriter) Flush() error {
+	if err := s.z.Flush(); err != nil {
+		return err
+	}
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	block, err := encodeSymbolBlock(s.buf, s.z.maxCodeLength)
+	if err != nil {
+		s.z.err = err
+		return err
+	}
+	err = s.z.writeEncodedBlock(block)
+	s.buf = s.buf[:0]
+	return err
+}
+
+// Close flushes the remaining symbols and ends the stream, it does not close the underlying writer
+func (s *SymbolWriter) Close() error {
+	if s.z.closed {
+		return s.z.err
+	}
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.z.Close()
+}
+
+// encodeSymbolBlock compresses a single block of symbols and creates its header
+// symbols: The symbols to be compressed, must not be empty
+// maxCodeLength: The longest code the block may use
+func encodeSymbolBlock(symbols []uint32, maxCodeLength int) (*encodedBlock, error) {
+	histogram := make(map[uint32]int)
+	for _, symbol := range symbols {
+		histogram[symbol]++
+	}
+
+	// Only the symbols that occur get a code, so the dump stays small however big the alphabet is
+	lengths, err := symbolCodeLengths(histogram, maxCodeLength)
+	if err != nil {
+		return nil, err
+	}
+	lengthDump := CreateSymbolLengthDump(lengths)
+
+	// A block of a single symbol carries no information beyond its size
+	var payload []byte
+	if len(lengths) > 1 {
+		codeTable := SymbolLengthsToCodeTable(lengths)
+		out := new(bytes.Buffer)
+		bw := common.NewBitWriter(out)
+		for _, symbol := range symbols {
+			writeCode(bw, codeTable[symbol])
+		}
+		bw.Flush()
+		payload = out.Bytes()
+	}
+
+	header := common.CreateBlockHeader(uint32(len(symbols)), uint16(len(lengthDump)), uint32(len(payload)))
+	return &encodedBlock{common.SymbolData(symbols), header, lengthDump, payload, nil, nil}, nil
+}
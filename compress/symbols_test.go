@@ -0,0 +1,86 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"io.whypeople/huffman/common"
+	"io.whypeople/huffman/decompress"
+)
+
+// symbolStream returns a block of symbols from a large alphabet and the stream a SymbolWriter
+// compresses it to
+// t: The test
+func symbolStream(t *testing.T) ([]uint32, []byte) {
+	t.Helper()
+	symbols := make([]uint32, common.SYMBOL_BLOCK_SIZE)
+	for i, b := range randomBytes(len(symbols), 6) {
+		symbols[i] = uint32(b) * 1000
+	}
+	var stream bytes.Buffer
+	s, err := NewSymbolWriter(&stream, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteSymbols(symbols); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return symbols, stream.Bytes()
+}
+
+// readSymbols reads every symbol of a stream with a Decoder's limits
+// d: The decoder
+// stream: The compressed stream
+func readSymbols(d *decompress.Decoder, stream []byte) ([]uint32, error) {
+	s, err := d.NewSymbolReader(bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+	var symbols []uint32
+	p := make([]uint32, 1000)
+	for {
+		n, err := s.ReadSymbols(p)
+		symbols = append(symbols, p[:n]...)
+		if err == io.EOF {
+			return symbols, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func TestSymbolReaderMemoryLimit(t *testing.T) {
+	symbols, stream := symbolStream(t)
+
+	// Every symbol takes 4 bytes, and the block and its payload are held at once
+	fits, err := decompress.NewDecoder(decompress.WithMemoryLimit(2 * 4 * common.SYMBOL_BLOCK_SIZE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := readSymbols(fits, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(symbols) {
+		t.Fatalf("decoded %d symbols, expected %d", len(decoded), len(symbols))
+	}
+	for i := range symbols {
+		if decoded[i] != symbols[i] {
+			t.Fatalf("symbol %d decoded to %d, expected %d", i, decoded[i], symbols[i])
+		}
+	}
+
+	tooSmall, err := decompress.NewDecoder(decompress.WithMemoryLimit(2*4*common.SYMBOL_BLOCK_SIZE - 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSymbols(tooSmall, stream); err == nil || !strings.Contains(err.Error(), "larger than the limit") {
+		t.Fatalf("a block bigger than the memory limit decoded with %v", err)
+	}
+}
@@ -1,11 +1,21 @@
 package compress
 
 import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"sort"
+
 	"io.whypeople/huffman/common"
 )
 
-// HistogramToHuffTree builds a Huffman Tree from a histogram and returns the root of the tree
-func HistogramToHuffTree(histogram map[byte]int) common.HuffNode {
+// HistogramToHuffTree builds a Huffman Tree from a histogram and returns the root of the tree.
+// Symbols can come from any alphabet, not just bytes. An empty histogram has no tree and returns nil,
+// a histogram of a single symbol returns a lone leaf.
+func HistogramToHuffTree(histogram map[uint32]int) common.HuffNode {
+	if len(histogram) == 0 {
+		return nil
+	}
 	heap := buildHuffMinHeap(histogram)
 
 	for heap.Size() > 1 {
@@ -20,7 +30,7 @@ func HistogramToHuffTree(histogram map[byte]int) common.HuffNode {
 }
 
 // buildHuffMinHeap builds a Huffman Min Heap from a histogram
-func buildHuffMinHeap(h map[byte]int) HuffMinHeap {
+func buildHuffMinHeap(h map[uint32]int) HuffMinHeap {
 	heap := NewHuffMinHeap()
 
 	for symbol, weight := range h {
@@ -31,35 +41,19 @@ func buildHuffMinHeap(h map[byte]int) HuffMinHeap {
 	return heap
 }
 
-// Wrapper types
-type HuffCode common.BitStack
-type HuffCodeTable map[byte]HuffCode 
-
-// HuffTreeToCodeTable builds a Huffman Code Table from a Huffman Tree
-// root: The root of the Huffman Tree
-func HuffTreeToCodeTable(root common.HuffNode) HuffCodeTable {
-	codeTable := make(HuffCodeTable)
-	huffcode := common.NewBitStack(common.MAX_CODE_SIZE)
-	buildHuffCodeTable(root, huffcode, codeTable)
-	return codeTable
+// A code ready to be written with a BitWriter, the first bit of the code is the lowest bit of Bits
+type HuffCode struct {
+	Bits   uint64
+	Length uint8
 }
+type HuffCodeTable map[uint32]HuffCode
 
-// Builds the Huffman Code Table recursively
-func buildHuffCodeTable(n common.HuffNode, code HuffCode, codeTable HuffCodeTable) {
-	if n.IsLeaf() {
-		// Actual symbols are leaf nodes
-		codeTable[n.Data().Symbol] = code.Copy()
-	} else {
-		// Traverse left
-		code.Push(0)
-		buildHuffCodeTable(n.Left(), code, codeTable)
-		code.Pop()
-
-		// Traverse right
-		code.Push(1)
-		buildHuffCodeTable(n.Right(), code, codeTable)
-		code.Pop()
-	}
+// HuffTreeToCodeTable builds a Huffman Code Table of canonical codes from a Huffman Tree,
+// only the depth of each symbol in the tree is used
+// root: The root of the Huffman Tree
+// alphabetSize: The number of symbols in the alphabet
+func HuffTreeToCodeTable(root common.HuffNode, alphabetSize int) HuffCodeTable {
+	return CodeLengthsToCodeTable(HuffTreeToCodeLengths(root, alphabetSize))
 }
 
 // CreateTreeDump creates a byte array that represents the huffman tree
@@ -70,11 +64,126 @@ func CreateTreeDump(root common.HuffNode) []byte {
 	}
 
 	if root.IsLeaf() {
-		return []byte{common.LEAF_DUMP_CHAR, root.Data().Symbol}
+		return []byte{common.LEAF_DUMP_CHAR, byte(root.Data().Symbol)}
 	}
 
 	dump = append(dump, CreateTreeDump(root.Left())...)
 	dump = append(dump, CreateTreeDump(root.Right())...)
 	dump = append(dump, common.INTERNAL_DUMP_CHAR)
 	return dump
-}
\ No newline at end of file
+}
+// HuffTreeToCodeLengths returns the length of every symbol's code in a Huffman Tree, symbols
+// that aren't in the tree have a length of 0
+// root: The root of the Huffman Tree
+// alphabetSize: The number of symbols in the alphabet
+func HuffTreeToCodeLengths(root common.HuffNode, alphabetSize int) []uint8 {
+	return common.DenseCodeLengths(HuffTreeToSymbolLengths(root), alphabetSize)
+}
+
+// HuffTreeToSymbolLengths returns the length of the code of every symbol in a Huffman Tree, in symbol order
+// root: The root of the Huffman Tree
+func HuffTreeToSymbolLengths(root common.HuffNode) []common.SymbolLength {
+	// An empty tree has no codes, and a tree with a single symbol still needs a code of 1 bit
+	if root == nil {
+		return nil
+	}
+	if root.IsLeaf() {
+		return []common.SymbolLength{{Symbol: root.Data().Symbol, Length: 1}}
+	}
+
+	lengths := make([]common.SymbolLength, 0)
+	buildCodeLengths(root, 0, &lengths)
+	sort.Slice(lengths, func(i, j int) bool {
+		return lengths[i].Symbol < lengths[j].Symbol
+	})
+	return lengths
+}
+
+// Finds the code lengths recursively
+func buildCodeLengths(n common.HuffNode, depth uint8, lengths *[]common.SymbolLength) {
+	if n.IsLeaf() {
+		*lengths = append(*lengths, common.SymbolLength{Symbol: n.Data().Symbol, Length: depth})
+	} else {
+		buildCodeLengths(n.Left(), depth+1, lengths)
+		buildCodeLengths(n.Right(), depth+1, lengths)
+	}
+}
+
+// CodeLengthsToCodeTable builds a Huffman Code Table of canonical codes from code lengths
+// lengths: The code length of every symbol
+func CodeLengthsToCodeTable(lengths []uint8) HuffCodeTable {
+	return SymbolLengthsToCodeTable(common.SparseCodeLengths(lengths))
+}
+
+// SymbolLengthsToCodeTable builds a Huffman Code Table of canonical codes from the code lengths of
+// the symbols that have a code
+// lengths: The symbols that have a code and their code lengths, in symbol order
+func SymbolLengthsToCodeTable(lengths []common.SymbolLength) HuffCodeTable {
+	codeTable := make(HuffCodeTable)
+	codes := common.CanonicalSymbolCodes(lengths)
+
+	for i, l := range lengths {
+		// Canonical codes are written starting with their most significant bit
+		codeTable[l.Symbol] = HuffCode{bits.Reverse64(codes[i]) >> (64 - l.Length), l.Length}
+	}
+	return codeTable
+}
+
+// CreateCodeLengthDump packs the code lengths of a canonical code into a byte array.
+// The first byte is the number of bits used for each length, the lengths follow in symbol order
+// with runs of zero lengths written as a 0 and an 8 bit run length (minus one)
+// lengths: The code length of every symbol
+func CreateCodeLengthDump(lengths []uint8) []byte {
+	width := uint(bits.Len8(longestCode(lengths)))
+	dump := bytes.NewBuffer([]byte{byte(width)})
+	bw := common.NewBitWriter(dump)
+
+	for i := 0; i < len(lengths); {
+		if lengths[i] != 0 {
+			bw.WriteBits(uint64(lengths[i]), width)
+			i++
+			continue
+		}
+
+		run := 0
+		for i+run < len(lengths) && lengths[i+run] == 0 && run < 256 {
+			run++
+		}
+		bw.WriteBits(0, width)
+		bw.WriteBits(uint64(run-1), 8)
+		i += run
+	}
+
+	bw.Flush()
+	return dump.Bytes()
+}
+
+// CreateSymbolLengthDump packs the code lengths of a canonical code over any alphabet into a byte
+// array. It starts with the number of symbols that have a code as a uvarint, then each of those
+// symbols in order as a uvarint gap from the symbol before it, followed by a byte for its length.
+// lengths: The symbols that have a code and their code lengths, in symbol order
+func CreateSymbolLengthDump(lengths []common.SymbolLength) []byte {
+	dump := make([]byte, 0, 2*len(lengths)+binary.MaxVarintLen32)
+	var varint [binary.MaxVarintLen32]byte
+
+	dump = append(dump, varint[:binary.PutUvarint(varint[:], uint64(len(lengths)))]...)
+	next := uint32(0)
+	for _, l := range lengths {
+		dump = append(dump, varint[:binary.PutUvarint(varint[:], uint64(l.Symbol-next))]...)
+		dump = append(dump, l.Length)
+		next = l.Symbol + 1
+	}
+	return dump
+}
+
+// longestCode returns the longest code length
+// lengths: The code length of every symbol
+func longestCode(lengths []uint8) uint8 {
+	longest := uint8(0)
+	for _, length := range lengths {
+		if length > longest {
+			longest = length
+		}
+	}
+	return longest
+}
@@ -0,0 +1,142 @@
+package compress
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"io.whypeople/huffman/common"
+	"io.whypeople/huffman/decompress"
+)
+
+// An input for a table test
+type namedInput struct {
+	name string
+	data []byte
+}
+
+// degenerateInputs returns inputs with no data or a single distinct byte
+func degenerateInputs() []namedInput {
+	return []namedInput{
+		{"empty", nil},
+		{"single byte", []byte{'x'}},
+		{"all zeros", make([]byte, 300000)},
+		{"single symbol", bytes.Repeat([]byte{'q'}, 70000)},
+	}
+}
+
+// varietyInputs returns inputs of several blocks that each backend has to handle well: text,
+// long runs, data that repeats at short and long periods and bytes with no structure at all
+func varietyInputs() []namedInput {
+	r := rand.New(rand.NewSource(11))
+	words := strings.Fields("the quick brown fox jumps over a lazy dog while func return if err != nil { } compress block tree")
+	var text bytes.Buffer
+	for text.Len() < 3*common.STREAM_BLOCK_SIZE+123 {
+		text.WriteString(words[r.Intn(len(words))])
+		if r.Intn(12) == 0 {
+			text.WriteByte('\n')
+		} else {
+			text.WriteByte(' ')
+		}
+	}
+
+	var runs []byte
+	for len(runs) < 3*common.STREAM_BLOCK_SIZE {
+		runs = append(runs, bytes.Repeat([]byte{byte(r.Intn(4))}, 1+r.Intn(5000))...)
+	}
+
+	chunk := make([]byte, 10000)
+	r.Read(chunk)
+	farChunk := make([]byte, common.MAX_LZ77_WINDOW+1)
+	r.Read(farChunk)
+	uniform := make([]byte, 3*common.STREAM_BLOCK_SIZE+5)
+	r.Read(uniform)
+
+	return []namedInput{
+		{"mixed text", text.Bytes()},
+		{"long runs", runs},
+		{"short period", bytes.Repeat([]byte("abcdefg"), 40000)},
+		{"long period", bytes.Repeat(chunk, 20)},
+		{"period past the window", bytes.Repeat(farChunk, 4)},
+		{"random", uniform},
+		{"text then random", append(append([]byte{}, text.Bytes()[:100000]...), uniform[:100000]...)},
+	}
+}
+
+func TestDegenerateInputsRoundTrip(t *testing.T) {
+	backends := []string{BACKEND_HUFFMAN, BACKEND_ADAPTIVE, BACKEND_LZ77, BACKEND_BWT, BACKEND_CONTEXT, BACKEND_GZIP}
+	for _, input := range degenerateInputs() {
+		for _, backend := range backends {
+			t.Run(input.name+"/"+backend, func(t *testing.T) {
+				e, err := NewEncoder(WithBackend(backend))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// Streamed
+				var stream bytes.Buffer
+				if err := e.Encode(&stream, bytes.NewReader(input.data)); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decodeAll(t, stream.Bytes()), input.data) {
+					t.Fatal("streamed data doesn't match")
+				}
+
+				// Files, which are compressed and decompressed in parallel
+				dir := t.TempDir()
+				paths := []string{filepath.Join(dir, "data"), filepath.Join(dir, "stream"), filepath.Join(dir, "decoded")}
+				if err := os.WriteFile(paths[0], input.data, 0600); err != nil {
+					t.Fatal(err)
+				}
+				files := make([]*os.File, len(paths))
+				for i, path := range paths {
+					if files[i], err = os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600); err != nil {
+						t.Fatal(err)
+					}
+					defer files[i].Close()
+				}
+				if _, err := e.EncodeFile(files[0], files[1]); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := files[1].Seek(0, 0); err != nil {
+					t.Fatal(err)
+				}
+				d, err := decompress.NewDecoder(decompress.WithConcurrency(4))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := d.DecodeFile(files[1], files[2]); err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := os.ReadFile(paths[2])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decoded, input.data) {
+					t.Fatal("file data doesn't match")
+				}
+			})
+		}
+	}
+}
+
+func TestHistogramToHuffTreeDegenerate(t *testing.T) {
+	if root := HistogramToHuffTree(map[uint32]int{}); root != nil {
+		t.Fatal("an empty histogram built a tree")
+	}
+	if lengths := HuffTreeToSymbolLengths(nil); lengths != nil {
+		t.Fatalf("an empty tree has code lengths %v", lengths)
+	}
+
+	root := HistogramToHuffTree(map[uint32]int{7: 100})
+	if root == nil || !root.IsLeaf() {
+		t.Fatal("a single symbol didn't build a lone leaf")
+	}
+	lengths := HuffTreeToSymbolLengths(root)
+	if len(lengths) != 1 || lengths[0].Symbol != 7 || lengths[0].Length != 1 {
+		t.Fatalf("a single symbol has code lengths %v, expected a 1 bit code", lengths)
+	}
+}
@@ -0,0 +1,320 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"io.whypeople/huffman/common"
+)
+
+// Writer is an io.WriteCloser that huffman compresses everything written to it.
+// Data is buffered into blocks, and each block is written with its own tree so
+// the input never has to be read twice. A block that codes smaller with the last
+// stored tree reuses it, and a block that doesn't compress is stored as it is.
+type Writer struct {
+	w             io.Writer
+	buf           []byte
+	maxCodeLength int         // The longest code a block may use
+	adaptive      bool        // Whether blocks are coded with an adaptive tree
+	window        int         // The LZ77 window, 0 if blocks aren't LZ77 coded
+	bwt           bool        // Whether blocks are Burrows-Wheeler transformed
+	context       bool        // Whether each byte is coded with a table picked by the byte before it
+	alphabetSize  uint32      // The number of symbols in the alphabet, 0 for bytes
+	checksum      int         // The checksum the stream carries, CHECKSUM_NONE or CHECKSUM_CRC32C
+	digest        hash.Hash32 // The checksum of all the data written so far
+	blocks        sync.Pool   // Block buffers for compressParallel, kept while the writer is reused
+	written       int64       // The number of bytes written to w
+	index         []common.IndexEntry
+	lastLengths   []uint8     // The code lengths of the last block that stored them, nil if they can't be reused
+	treeAge       int         // The number of blocks written since the last block that stored a tree
+	wroteHeader   bool
+	closed        bool
+	err           error
+}
+
+// NewWriter returns a new Writer, the caller must Close it to flush the final block
+// w: The writer to write the compressed stream to
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterMaxCodeLength(w, common.MAX_CODE_SIZE)
+	return z
+}
+
+// NewWriterMaxCodeLength is like NewWriter but limits the length of every code, smaller limits let
+// decoders use smaller tables at a small cost in compression
+// w: The writer to write the compressed stream to
+// maxCodeLength: The longest code allowed, from MIN_CODE_LENGTH_LIMIT to MAX_CODE_SIZE bits
+func NewWriterMaxCodeLength(w io.Writer, maxCodeLength int) (*Writer, error) {
+	if maxCodeLength < common.MIN_CODE_LENGTH_LIMIT || maxCodeLength > common.MAX_CODE_SIZE {
+		return nil, fmt.Errorf("max code length must be between %d and %d bits", common.MIN_CODE_LENGTH_LIMIT, common.MAX_CODE_SIZE)
+	}
+	return &Writer{
+		w:             w,
+		buf:           make([]byte, 0, common.STREAM_BLOCK_SIZE),
+		maxCodeLength: maxCodeLength,
+		checksum:      common.CHECKSUM_CRC32C,
+		digest:        common.NewChecksum(),
+	}, nil
+}
+
+// NewAdaptiveWriter is like NewWriter but codes every block with an adaptive Huffman tree, which
+// is updated as each symbol is coded so no tree is stored
+// w: The writer to write the compressed stream to
+func NewAdaptiveWriter(w io.Writer) *Writer {
+	z := NewWriter(w)
+	z.adaptive = true
+	return z
+}
+
+// NewLZ77Writer is like NewWriter but replaces repeated data in every block with matches before
+// huffman coding, literals and match lengths share one tree and match distances get another
+// w: The writer to write the compressed stream to
+// window: The furthest back a match can be, a power of two from MIN_LZ77_WINDOW to MAX_LZ77_WINDOW
+func NewLZ77Writer(w io.Writer, window int) (*Writer, error) {
+	if window < common.MIN_LZ77_WINDOW || window > common.MAX_LZ77_WINDOW || window&(window-1) != 0 {
+		return nil, fmt.Errorf("LZ77 window must be a power of two between %d and %d", common.MIN_LZ77_WINDOW, common.MAX_LZ77_WINDOW)
+	}
+	z := NewWriter(w)
+	z.window = window
+	return z, nil
+}
+
+// NewBWTWriter is like NewWriter but Burrows-Wheeler transforms large blocks and codes them with
+// several trees, which compresses text much better at the cost of speed and memory
+// w: The writer to write the compressed stream to
+func NewBWTWriter(w io.Writer) *Writer {
+	z := NewWriter(w)
+	z.bwt = true
+	z.buf = make([]byte, 0, common.BWT_BLOCK_SIZE)
+	return z
+}
+
+// NewContextWriter is like NewWriter but codes each byte with a tree picked by the byte before it,
+// contexts that are followed by similar bytes share a tree so the trees pay for themselves
+// w: The writer to write the compressed stream to
+func NewContextWriter(w io.Writer) *Writer {
+	z := NewWriter(w)
+	z.context = true
+	return z
+}
+
+// SetSeekInterval makes blocks shorter than they'd be otherwise. Every block starts with a fresh
+// decoder and has an entry in the block index, so a range of the data can be decoded from the
+// block it starts in. Shorter blocks mean less decoding for each range at some cost in
+// compression, blocks that are much like the one before reuse its tree to keep that small.
+// The seek interval is just the block size, an Encoder sets it with WithBlockSize and the
+// command line with --block-size. It must be called before anything is written.
+// interval: The most data in a block, from MIN_BLOCK_SIZE to the writer's block size
+func (z *Writer) SetSeekInterval(interval int) error {
+	if z.wroteHeader || len(z.buf) != 0 {
+		return errors.New("seek interval must be set before anything is written")
+	}
+	if interval < common.MIN_BLOCK_SIZE || interval > cap(z.buf) {
+		return fmt.Errorf("seek interval must be between %d and %d bytes", common.MIN_BLOCK_SIZE, cap(z.buf))
+	}
+	z.buf = make([]byte, 0, interval)
+	return nil
+}
+
+// Reset discards the writer's state and makes it write a new stream to w. Its settings and buffers
+// are kept, so one writer can compress many inputs.
+// w: The writer to write the compressed stream to
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.buf = z.buf[:0]
+	z.digest.Reset()
+	z.written = 0
+	z.index = z.index[:0]
+	z.lastLengths, z.treeAge = nil, 0
+	z.wroteHeader, z.closed, z.err = false, false, nil
+}
+
+// Write buffers p and compresses every block that fills up
+// p: The data to be compressed
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errors.New("write to closed writer")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		copied := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+copied]
+		p = p[copied:]
+		n += copied
+
+		if len(z.buf) == cap(z.buf) {
+			if err := z.Flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush compresses any buffered data as a (possibly short) block and writes it out
+func (z *Writer) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return errors.New("flush of closed writer")
+	}
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+	if len(z.buf) == 0 {
+		return nil
+	}
+
+	block, err := z.encodeBlock(z.buf)
+	if err != nil {
+		z.err = err
+		return err
+	}
+	err = z.writeEncodedBlock(block)
+	z.buf = z.buf[:0]
+	return err
+}
+
+// writeEncodedBlock writes a compressed block and adds it to the checksum and block index
+// block: The compressed block
+func (z *Writer) writeEncodedBlock(block *encodedBlock) error {
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+	z.chooseBlockType(block)
+	if z.checksum == common.CHECKSUM_CRC32C {
+		z.digest.Write(block.data)
+	}
+	z.index = append(z.index, common.IndexEntry{BitOffset: uint64(z.written) * 8, UncompressedSize: block.header.UncompressedSize})
+	z.writeBlock(block.header, block.treeDump, block.payload)
+	return z.err
+}
+
+// chooseBlockType rewrites a block to reuse the last stored tree, or to store its data, if that's
+// smaller than coding it with its own tree. It depends on the blocks written before, so it's made
+// as blocks are written rather than while they're encoded concurrently.
+// block: The compressed block
+func (z *Writer) chooseBlockType(block *encodedBlock) {
+	blockType := common.BLOCK_CODED
+	size := len(block.treeDump) + len(block.payload)
+	if block.histogram != nil && z.lastLengths != nil && z.treeAge < common.MAX_TREE_REUSE {
+		if reused, ok := reusedSize(block.histogram, z.lastLengths); ok && reused < size {
+			blockType, size = common.BLOCK_REUSED_TREE, reused
+		}
+	}
+	if len(block.data) < size {
+		blockType = common.BLOCK_STORED
+	}
+
+	switch blockType {
+	case common.BLOCK_CODED:
+		z.lastLengths = block.lengths
+		z.treeAge = 0
+		return
+	case common.BLOCK_REUSED_TREE:
+		block.payload = encodeWithLengths(block.data, z.lastLengths)
+	case common.BLOCK_STORED:
+		block.payload = block.data
+	}
+	block.treeDump = nil
+	block.header = &common.BlockHeader{
+		UncompressedSize: block.header.UncompressedSize,
+		PayloadSize:      uint32(len(block.payload)),
+		BlockType:        uint8(blockType),
+	}
+	z.treeAge++
+}
+
+// Close flushes the remaining data and ends the stream, it does not close the underlying writer
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	if err := z.Flush(); err != nil {
+		return err
+	}
+	z.closed = true
+
+	// An empty block marks the end of the stream, and is followed by the checksum of the data
+	// and the block index
+	z.writeBlock(common.CreateBlockHeader(0, 0, 0), nil, nil)
+	if z.checksum == common.CHECKSUM_CRC32C {
+		z.write(&common.StreamTrailer{DataChecksum: z.digest.Sum32()})
+	}
+	z.write(z.index)
+	z.write(common.CreateIndexFooter(z.index))
+	return z.err
+}
+
+// writeHeader writes the stream header if it hasn't been written yet
+func (z *Writer) writeHeader() error {
+	if !z.wroteHeader {
+		z.wroteHeader = true
+		flags := common.FLAG_BLOCK_INDEX
+		if z.checksum == common.CHECKSUM_CRC32C {
+			flags |= common.FLAG_CHECKSUM
+		}
+		if z.adaptive {
+			flags |= common.FLAG_ADAPTIVE
+		} else {
+			flags |= common.FLAG_CANONICAL_CODES
+		}
+		if z.window != 0 {
+			flags |= common.FLAG_LZ77
+		}
+		if z.bwt {
+			flags |= common.FLAG_BWT
+		}
+		if z.context {
+			flags |= common.FLAG_CONTEXT
+		}
+		alphabetSize := uint32(common.ALPHABET_SIZE)
+		if z.alphabetSize != 0 {
+			flags |= common.FLAG_ALPHABET
+			alphabetSize = z.alphabetSize
+		}
+		header := common.CreateStreamHeader(uint8(flags), uint8(z.maxCodeLength), alphabetSize)
+		for _, field := range header.Fields() {
+			z.write(field)
+		}
+	}
+	return z.err
+}
+
+// writeBlock writes a block header, its code length dump and checksum, and the block's payload
+// header: The block header
+// treeDump: The block's code length dump, empty for adaptive blocks
+// payload: The block's huffman coded data
+func (z *Writer) writeBlock(header *common.BlockHeader, treeDump []byte, payload []byte) {
+	for _, field := range header.Fields(common.FORMAT_VERSION) {
+		z.write(field)
+	}
+	z.write(treeDump)
+	if z.checksum == common.CHECKSUM_CRC32C {
+		z.write(common.BlockChecksum(header, common.FORMAT_VERSION, treeDump))
+	}
+	z.write(payload)
+}
+
+// write writes raw bytes or fixed size data to the underlying writer, keeping the first error
+// data: The data to write
+func (z *Writer) write(data interface{}) {
+	if z.err != nil {
+		return
+	}
+	if raw, ok := data.([]byte); ok {
+		_, z.err = z.w.Write(raw)
+		z.written += int64(len(raw))
+	} else {
+		z.err = binary.Write(z.w, common.StreamByteOrder, data)
+		z.written += int64(binary.Size(data))
+	}
+}
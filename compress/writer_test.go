@@ -0,0 +1,228 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"io.whypeople/huffman/common"
+	"io.whypeople/huffman/decompress"
+)
+
+// compressBytes compresses data with a Writer
+// t: The test
+// z: The writer, which writes to stream
+// stream: Where the writer writes
+// data: The data to compress
+func compressBytes(t *testing.T, z *Writer, stream *bytes.Buffer, data []byte) []byte {
+	t.Helper()
+	if _, err := z.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return stream.Bytes()
+}
+
+func TestWriterHeaderLayout(t *testing.T) {
+	var out bytes.Buffer
+	stream := compressBytes(t, NewWriter(&out), &out, []byte("header"))
+
+	// The magic number is little endian and the version follows it, whatever machine wrote it
+	want := []byte{0xEF, 0xBE, 0xDE, 0xC0, common.FORMAT_VERSION}
+	if !bytes.Equal(stream[:len(want)], want) {
+		t.Fatalf("the stream starts with % x, expected % x", stream[:len(want)], want)
+	}
+	if flags := stream[5]; flags&common.FLAG_CHECKSUM == 0 || flags&common.FLAG_BLOCK_INDEX == 0 {
+		t.Fatalf("the stream has flags %#x", flags)
+	}
+	if decoded := decodeAll(t, stream); string(decoded) != "header" {
+		t.Fatalf("decoded %q", decoded)
+	}
+}
+
+// The size of a stream header without an alphabet size, and of a version 3 block header
+const STREAM_HEADER_SIZE = 7
+const BLOCK_HEADER_SIZE = 11
+
+// flipBit returns a copy of a stream with one bit flipped
+// stream: The stream
+// at: The byte to flip a bit of
+func flipBit(stream []byte, at int) []byte {
+	flipped := append([]byte{}, stream...)
+	flipped[at] ^= 0x08
+	return flipped
+}
+
+// checksumSection decodes a stream and returns the section of the checksum error it fails with,
+// or "" if it fails some other way
+// t: The test
+// stream: The compressed stream
+func checksumSection(t *testing.T, stream []byte) string {
+	t.Helper()
+	d, err := decompress.NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Decode(io.Discard, bytes.NewReader(stream))
+	if err == nil {
+		t.Fatal("a corrupt stream decoded")
+	}
+	var checksumErr *decompress.ChecksumError
+	if !errors.As(err, &checksumErr) {
+		return ""
+	}
+	return checksumErr.Section
+}
+
+func TestChecksumDetectsFlippedBits(t *testing.T) {
+	// A single stored block, so any flipped payload bit decodes to different data
+	data := make([]byte, common.MIN_BLOCK_SIZE)
+	rand.New(rand.NewSource(7)).Read(data)
+	var out bytes.Buffer
+	stream := compressBytes(t, NewWriter(&out), &out, data)
+	if stream[STREAM_HEADER_SIZE+BLOCK_HEADER_SIZE-1] != common.BLOCK_STORED {
+		t.Fatal("random data wasn't stored")
+	}
+	treeSize := int(common.StreamByteOrder.Uint16(stream[STREAM_HEADER_SIZE+4:]))
+	payload := STREAM_HEADER_SIZE + BLOCK_HEADER_SIZE + treeSize + 4
+	indexSize := binary.Size(common.IndexEntry{}) + binary.Size(common.IndexFooter{})
+	trailer := len(stream) - indexSize - binary.Size(common.StreamTrailer{})
+
+	tests := []struct {
+		name    string
+		at      int
+		section string
+	}{
+		{"block size", STREAM_HEADER_SIZE, decompress.SECTION_BLOCK_HEADER},
+		{"payload size", STREAM_HEADER_SIZE + 6, decompress.SECTION_BLOCK_HEADER},
+		{"block header checksum", payload - 2, decompress.SECTION_BLOCK_HEADER},
+		{"first payload byte", payload, decompress.SECTION_DATA},
+		{"middle payload byte", payload + len(data)/2, decompress.SECTION_DATA},
+		{"last payload byte", payload + len(data) - 1, decompress.SECTION_DATA},
+		{"data checksum", trailer, decompress.SECTION_DATA},
+	}
+	for _, test := range tests {
+		if section := checksumSection(t, flipBit(stream, test.at)); section != test.section {
+			t.Errorf("%s: flipping byte %d failed the %q checksum, expected %q", test.name, test.at, section, test.section)
+		}
+	}
+
+	// The index is only read by decoders that seek
+	flipped := flipBit(stream, len(stream)-indexSize)
+	_, err := decompress.NewReaderAt(bytes.NewReader(flipped), int64(len(flipped)))
+	var checksumErr *decompress.ChecksumError
+	if !errors.As(err, &checksumErr) || checksumErr.Section != decompress.SECTION_BLOCK_INDEX {
+		t.Errorf("flipping a bit of the index failed with %v", err)
+	}
+}
+
+func TestChecksumDetectsFlippedCodedBits(t *testing.T) {
+	// A flipped bit in a coded block can throw the decoder off so the block ends early, which is
+	// an error of its own, but it mustn't ever decode
+	data := randomBytes(common.MIN_BLOCK_SIZE, 8)
+	var out bytes.Buffer
+	stream := compressBytes(t, NewWriter(&out), &out, data)
+	if stream[STREAM_HEADER_SIZE+BLOCK_HEADER_SIZE-1] != common.BLOCK_CODED {
+		t.Fatal("skewed data wasn't coded")
+	}
+	treeSize := int(common.StreamByteOrder.Uint16(stream[STREAM_HEADER_SIZE+4:]))
+	payloadSize := int(common.StreamByteOrder.Uint32(stream[STREAM_HEADER_SIZE+6:]))
+	tree := STREAM_HEADER_SIZE + BLOCK_HEADER_SIZE
+	payload := tree + treeSize + 4
+	for at := tree; at < tree+treeSize; at++ {
+		if section := checksumSection(t, flipBit(stream, at)); section != decompress.SECTION_BLOCK_HEADER {
+			t.Fatalf("flipping tree byte %d failed the %q checksum", at-tree, section)
+		}
+	}
+	for at := payload; at < payload+payloadSize; at += 7 {
+		checksumSection(t, flipBit(stream, at))
+	}
+}
+
+// blockTypes returns the type of every block of a stream
+// t: The test
+// stream: The compressed stream, with checksums
+func blockTypes(t *testing.T, stream []byte) []uint8 {
+	t.Helper()
+	types := make([]uint8, 0)
+	for at := STREAM_HEADER_SIZE; ; {
+		header := stream[at:]
+		size := common.StreamByteOrder.Uint32(header)
+		if size == 0 {
+			return types
+		}
+		types = append(types, header[BLOCK_HEADER_SIZE-1])
+		treeSize := int(common.StreamByteOrder.Uint16(header[4:]))
+		payloadSize := int(common.StreamByteOrder.Uint32(header[6:]))
+		at += BLOCK_HEADER_SIZE + treeSize + 4 + payloadSize
+	}
+}
+
+func TestWriterChoosesBlockTypes(t *testing.T) {
+	text := []byte(strings.Repeat("a block with the same distribution as the last. ", common.MIN_BLOCK_SIZE)[:common.MIN_BLOCK_SIZE])
+	uniform := make([]byte, common.MIN_BLOCK_SIZE)
+	rand.New(rand.NewSource(9)).Read(uniform)
+	other := bytes.Repeat([]byte("0123456789"), common.MIN_BLOCK_SIZE/10+1)[:common.MIN_BLOCK_SIZE]
+
+	// A tree can only be reused by MAX_TREE_REUSE blocks before it's stored again
+	limitBlocks := make([][]byte, common.MAX_TREE_REUSE+3)
+	limitTypes := make([]uint8, len(limitBlocks))
+	for i := range limitBlocks {
+		limitBlocks[i], limitTypes[i] = text, common.BLOCK_REUSED_TREE
+	}
+	limitTypes[0], limitTypes[common.MAX_TREE_REUSE+1] = common.BLOCK_CODED, common.BLOCK_CODED
+
+	tests := []struct {
+		name   string
+		blocks [][]byte
+		types  []uint8
+	}{
+		{"incompressible", [][]byte{uniform, uniform, uniform},
+			[]uint8{common.BLOCK_STORED, common.BLOCK_STORED, common.BLOCK_STORED}},
+		{"repeated distribution", [][]byte{text, text, text},
+			[]uint8{common.BLOCK_CODED, common.BLOCK_REUSED_TREE, common.BLOCK_REUSED_TREE}},
+		{"reuse across a stored block", [][]byte{text, uniform, text},
+			[]uint8{common.BLOCK_CODED, common.BLOCK_STORED, common.BLOCK_REUSED_TREE}},
+		{"new distribution", [][]byte{text, other, other},
+			[]uint8{common.BLOCK_CODED, common.BLOCK_CODED, common.BLOCK_REUSED_TREE}},
+		{"reuse limit", limitBlocks, limitTypes},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := bytes.Join(test.blocks, nil)
+			var out bytes.Buffer
+			z := NewWriter(&out)
+			if err := z.SetSeekInterval(common.MIN_BLOCK_SIZE); err != nil {
+				t.Fatal(err)
+			}
+			stream := compressBytes(t, z, &out, data)
+			if types := blockTypes(t, stream); !bytes.Equal(types, test.types) {
+				t.Fatalf("blocks have types %v, expected %v", types, test.types)
+			}
+			if !bytes.Equal(decodeAll(t, stream), data) {
+				t.Fatal("the stream decoded to different data")
+			}
+		})
+	}
+}
+
+func TestStoredBlocksBoundStreamSize(t *testing.T) {
+	// Incompressible data only grows by the block headers and the stream's own overhead
+	data := make([]byte, 10*common.STREAM_BLOCK_SIZE)
+	rand.New(rand.NewSource(10)).Read(data)
+	var out bytes.Buffer
+	stream := compressBytes(t, NewWriter(&out), &out, data)
+	blocks := len(data) / common.STREAM_BLOCK_SIZE
+	perBlock := BLOCK_HEADER_SIZE + 4 + binary.Size(common.IndexEntry{})
+	overhead := STREAM_HEADER_SIZE + BLOCK_HEADER_SIZE + 4 + binary.Size(common.StreamTrailer{}) + binary.Size(common.IndexFooter{})
+	if len(stream) != len(data)+blocks*perBlock+overhead {
+		t.Fatalf("%d bytes of random data compressed to %d bytes, expected %d", len(data), len(stream), len(data)+blocks*perBlock+overhead)
+	}
+}
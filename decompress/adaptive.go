@@ -0,0 +1,21 @@
+package decompress
+
+import (
+	"io.whypeople/huffman/common"
+)
+
+// decodeAdaptive decodes len(out) symbols that were coded with an adaptive Huffman tree
+// br: The bits to decode
+// out: The buffer to write the decoded symbols to
+func decodeAdaptive(br *common.BitReader, out []byte) error {
+	tree := common.NewAdaptiveTree()
+	for i := range out {
+		symbol, err := tree.Decode(br)
+		if err != nil {
+			return unexpected(err)
+		}
+		tree.Update(symbol)
+		out[i] = symbol
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package decompress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"io.whypeople/huffman/common"
+)
+
+// ArchiveReader reads the central directory of an archive, and decompresses and extracts its entries
+type ArchiveReader struct {
+	r         io.ReaderAt
+	entries   []common.ArchiveEntry
+	locations []common.DirectoryEntry // Where the data of each entry is stored
+}
+
+// NewArchiveReader returns a new ArchiveReader, only the central directory is read
+// r: The archive
+// size: The size of the archive
+func NewArchiveReader(r io.ReaderAt, size int64) (*ArchiveReader, error) {
+	var header common.ArchiveHeader
+	if err := binary.Read(io.NewSectionReader(r, 0, size), common.StreamByteOrder, &header); err != nil {
+		return nil, unexpected(err)
+	}
+	if header.MagicNumber != common.ARCHIVE_MAGIC_NUMBER {
+		return nil, ErrBadMagic
+	}
+	if header.Version == 0 || header.Version > common.ARCHIVE_VERSION {
+		return nil, fmt.Errorf("unsupported archive version %d, this build reads up to version %d", header.Version, common.ARCHIVE_VERSION)
+	}
+
+	// The footer at the end of the archive says where the directory is
+	var footer common.DirectoryFooter
+	footerSize := int64(binary.Size(footer))
+	dataStart := int64(binary.Size(header))
+	if size < dataStart+footerSize {
+		return nil, ErrTruncated
+	}
+	if err := binary.Read(io.NewSectionReader(r, size-footerSize, footerSize), common.StreamByteOrder, &footer); err != nil {
+		return nil, unexpected(err)
+	}
+	if footer.MagicNumber != common.DIRECTORY_MAGIC_NUMBER {
+		return nil, fmt.Errorf("%w in the central directory footer", ErrBadMagic)
+	}
+	directoryEnd := size - footerSize
+	if footer.DirectoryOffset < uint64(dataStart) || footer.DirectoryOffset > uint64(directoryEnd) {
+		return nil, errors.New("invalid central directory offset")
+	}
+
+	directory := make([]byte, directoryEnd-int64(footer.DirectoryOffset))
+	if _, err := r.ReadAt(directory, int64(footer.DirectoryOffset)); err != nil {
+		return nil, unexpected(err)
+	}
+	if computed := common.DirectoryChecksum(directory); computed != footer.DirectoryChecksum {
+		return nil, &ChecksumError{Section: SECTION_DIRECTORY, Stored: footer.DirectoryChecksum, Computed: computed}
+	}
+
+	a := &ArchiveReader{r: r}
+	dir := bytes.NewReader(directory)
+	for i := uint32(0); i < footer.EntryCount; i++ {
+		var location common.DirectoryEntry
+		if err := binary.Read(dir, common.StreamByteOrder, &location); err != nil {
+			return nil, fmt.Errorf("%w in the central directory", ErrTruncated)
+		}
+		names := make([]byte, int(location.PathLength)+int(location.LinkLength))
+		if _, err := io.ReadFull(dir, names); err != nil {
+			return nil, fmt.Errorf("%w in the central directory", ErrTruncated)
+		}
+
+		// An entry's data must lie between the archive header and the directory
+		if location.DataOffset < uint64(dataStart) || location.DataOffset > footer.DirectoryOffset ||
+			location.DataSize > footer.DirectoryOffset-location.DataOffset {
+			return nil, errors.New("invalid central directory entry")
+		}
+
+		a.entries = append(a.entries, common.ArchiveEntry{
+			Path:       string(names[:location.PathLength]),
+			Type:       location.Type,
+			Mode:       location.Mode,
+			ModTime:    location.ModTime,
+			Size:       location.Size,
+			LinkTarget: string(names[location.PathLength:]),
+		})
+		a.locations = append(a.locations, location)
+	}
+	if dir.Len() != 0 {
+		return nil, errors.New("invalid central directory")
+	}
+	return a, nil
+}
+
+// Entries returns the entries of the archive, in the order they were added
+func (a *ArchiveReader) Entries() []common.ArchiveEntry {
+	return a.entries
+}
+
+// Open returns a reader that decompresses the data of a file entry
+// i: The index of the entry
+func (a *ArchiveReader) Open(i int) (io.Reader, error) {
+	if a.entries[i].Type != common.ENTRY_FILE {
+		return nil, fmt.Errorf("%s is not a file", a.entries[i].Path)
+	}
+	location := a.locations[i]
+	return NewReader(io.NewSectionReader(a.r, int64(location.DataOffset), int64(location.DataSize)))
+}
+
+// Extract recreates every entry of the archive under a directory, with its permissions and
+// modification time. Symlinks are created last, and an archive with an entry inside one of its
+// symlinks is rejected, so no entry can be written through a symlink from the archive. Symlinks
+// already in dir aren't followed either.
+// dir: The directory to extract the archive into
+func (a *ArchiveReader) Extract(dir string) error {
+	// Nothing is extracted from an archive with an entry that would land outside dir
+	if err := a.checkPaths(); err != nil {
+		return err
+	}
+
+	symlinks := make([]int, 0)
+	directories := make([]int, 0)
+	for i, entry := range a.entries {
+		name := filepath.Join(dir, filepath.FromSlash(entry.Path))
+		if err := checkParents(dir, entry.Path); err != nil {
+			return err
+		}
+
+		switch entry.Type {
+		case common.ENTRY_FILE:
+			if err := a.extractFile(i, name); err != nil {
+				return err
+			}
+		case common.ENTRY_DIRECTORY:
+			// Directories stay writable until everything in them has been extracted
+			if err := os.MkdirAll(name, 0700); err != nil {
+				return err
+			}
+			directories = append(directories, i)
+		case common.ENTRY_SYMLINK:
+			symlinks = append(symlinks, i)
+		default:
+			return fmt.Errorf("%s has unknown entry type %d", entry.Path, entry.Type)
+		}
+	}
+
+	for _, i := range symlinks {
+		name := filepath.Join(dir, filepath.FromSlash(a.entries[i].Path))
+		if err := checkParents(dir, a.entries[i].Path); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		if err := os.Symlink(a.entries[i].LinkTarget, name); err != nil {
+			return err
+		}
+	}
+
+	// Directories are finished deepest first, so setting a directory's time isn't undone by
+	// finishing the directories inside it
+	for j := len(directories) - 1; j >= 0; j-- {
+		entry := a.entries[directories[j]]
+		name := filepath.Join(dir, filepath.FromSlash(entry.Path))
+		if err := setMetadata(name, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPaths makes sure every entry path is clean and relative, no two entries share a path and
+// no entry is inside a symlink entry
+func (a *ArchiveReader) checkPaths() error {
+	types := make(map[string]uint8, len(a.entries))
+	for _, entry := range a.entries {
+		if err := common.CheckArchivePath(entry.Path); err != nil {
+			return err
+		}
+		if _, ok := types[entry.Path]; ok {
+			return fmt.Errorf("archive entry %s appears more than once", entry.Path)
+		}
+		types[entry.Path] = entry.Type
+	}
+
+	for _, entry := range a.entries {
+		for parent := path.Dir(entry.Path); parent != "."; parent = path.Dir(parent) {
+			if parentType, ok := types[parent]; ok && parentType == common.ENTRY_SYMLINK {
+				return fmt.Errorf("archive entry %s is inside the symlink %s", entry.Path, parent)
+			}
+		}
+	}
+	return nil
+}
+
+// checkParents makes sure none of the directories between dir and an entry is a symlink, so
+// creating the entry can't follow one out of dir
+// dir: The directory the archive is extracted into
+// name: The slash separated path of the entry
+func checkParents(dir string, name string) error {
+	parent := dir
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			break
+		}
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("can't extract %s through the symlink %s", name, parent)
+		}
+	}
+	return nil
+}
+
+// Verify decompresses the data of every file entry and throws it away, checking each one decodes
+// to the size the central directory gives it
+func (a *ArchiveReader) Verify() error {
+	for i, entry := range a.entries {
+		if entry.Type == common.ENTRY_FILE {
+			if err := a.copyEntry(i, io.Discard); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// extractFile decompresses a file entry to a path
+// i: The index of the entry
+// name: The path to write the file to
+func (a *ArchiveReader) extractFile(i int, name string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = a.copyEntry(i, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return setMetadata(name, a.entries[i])
+}
+
+// copyEntry decompresses a file entry to a writer and checks its size
+// i: The index of the entry
+// w: The writer to write the data to
+func (a *ArchiveReader) copyEntry(i int, w io.Writer) error {
+	entry := a.entries[i]
+	reader, err := a.Open(i)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(w, reader)
+	if err != nil {
+		return err
+	}
+	if uint64(size) != entry.Size {
+		return fmt.Errorf("%s decoded to %d bytes instead of %d", entry.Path, size, entry.Size)
+	}
+	return nil
+}
+
+// setMetadata sets the permissions and modification time of an extracted file or directory
+// name: The path of the file or directory
+// entry: The archive entry it was extracted from
+func setMetadata(name string, entry common.ArchiveEntry) error {
+	if err := os.Chmod(name, os.FileMode(entry.Mode).Perm()); err != nil {
+		return err
+	}
+	modTime := time.Unix(0, entry.ModTime)
+	return os.Chtimes(name, modTime, modTime)
+}
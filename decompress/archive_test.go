@@ -0,0 +1,119 @@
+package decompress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"io.whypeople/huffman/common"
+)
+
+// craftArchive builds an archive of directories and symlinks by hand, without the checks an
+// ArchiveWriter would make
+// t: The test
+// entries: The entries, which must not be files
+func craftArchive(t *testing.T, entries []common.ArchiveEntry) *ArchiveReader {
+	t.Helper()
+	var archive, directory bytes.Buffer
+	binary.Write(&archive, common.StreamByteOrder, common.CreateArchiveHeader())
+	offset := uint64(archive.Len())
+	for _, entry := range entries {
+		location := common.DirectoryEntry{
+			Type:       entry.Type,
+			Mode:       0755,
+			DataOffset: offset,
+			PathLength: uint16(len(entry.Path)),
+			LinkLength: uint16(len(entry.LinkTarget)),
+		}
+		binary.Write(&directory, common.StreamByteOrder, &location)
+		directory.WriteString(entry.Path + entry.LinkTarget)
+	}
+	archive.Write(directory.Bytes())
+	binary.Write(&archive, common.StreamByteOrder, common.CreateDirectoryFooter(uint32(len(entries)), offset, directory.Bytes()))
+
+	a, err := NewArchiveReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// checkEmpty fails the test if a directory has anything in it
+// t: The test
+// dir: The directory
+func checkEmpty(t *testing.T, dir string) {
+	t.Helper()
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("%s has %d entries, expected none", dir, len(names))
+	}
+}
+
+func TestExtractRejectsEntriesInsideSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	tests := []struct {
+		name    string
+		entries []common.ArchiveEntry
+	}{
+		{"symlink through symlink", []common.ArchiveEntry{
+			{Path: "d", Type: common.ENTRY_SYMLINK, LinkTarget: outside},
+			{Path: "d/pwned", Type: common.ENTRY_SYMLINK, LinkTarget: "anything"},
+		}},
+		{"directory through symlink", []common.ArchiveEntry{
+			{Path: "d", Type: common.ENTRY_SYMLINK, LinkTarget: outside},
+			{Path: "d/sub/pwned", Type: common.ENTRY_DIRECTORY},
+		}},
+		{"entry before the symlink", []common.ArchiveEntry{
+			{Path: "d/pwned", Type: common.ENTRY_SYMLINK, LinkTarget: "anything"},
+			{Path: "d", Type: common.ENTRY_SYMLINK, LinkTarget: outside},
+		}},
+		{"duplicate paths", []common.ArchiveEntry{
+			{Path: "d", Type: common.ENTRY_DIRECTORY},
+			{Path: "d", Type: common.ENTRY_SYMLINK, LinkTarget: outside},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := craftArchive(t, test.entries).Extract(dir); err == nil {
+				t.Fatal("the archive was extracted")
+			}
+			checkEmpty(t, dir)
+			checkEmpty(t, outside)
+		})
+	}
+}
+
+func TestExtractDoesNotFollowExistingSymlinks(t *testing.T) {
+	outside, dir := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "d")); err != nil {
+		t.Fatal(err)
+	}
+	a := craftArchive(t, []common.ArchiveEntry{{Path: "d/pwned", Type: common.ENTRY_DIRECTORY}})
+	if err := a.Extract(dir); err == nil {
+		t.Fatal("the archive was extracted through a symlink")
+	}
+	checkEmpty(t, outside)
+}
+
+func TestExtractSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	a := craftArchive(t, []common.ArchiveEntry{
+		{Path: "sub", Type: common.ENTRY_DIRECTORY},
+		{Path: "sub/link", Type: common.ENTRY_SYMLINK, LinkTarget: "../target"},
+		{Path: "top", Type: common.ENTRY_SYMLINK, LinkTarget: "sub"},
+	})
+	if err := a.Extract(dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"sub/link": "../target", "top": "sub"} {
+		if target, err := os.Readlink(filepath.Join(dir, name)); err != nil || target != want {
+			t.Fatalf("%s points to %q, %v, expected %q", name, target, err, want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package decompress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"io.whypeople/huffman/common"
+)
+
+// A block that has been read from a stream but not decoded yet
+type rawBlock struct {
+	header   common.BlockHeader
+	treeDump []byte
+	payload  []byte
+	bits     *common.BitReader // Reads the block's payload
+}
+
+// The dump of the last block in a stream that stored one, which the blocks after it can reuse
+type sharedTree struct {
+	dump []byte // nil until a block stores a dump
+	age  int    // The number of blocks since the one that stored the dump
+}
+
+// share remembers the dump of a block that stores one, and gives it to a block that reuses it
+// raw: The block, in stream order
+func (t *sharedTree) share(raw *rawBlock) error {
+	switch raw.header.BlockType {
+	case common.BLOCK_CODED:
+		t.dump, t.age = raw.treeDump, 0
+		return nil
+	case common.BLOCK_REUSED_TREE:
+		if t.dump == nil {
+			return badTree("block reuses a tree but no block before it stored one")
+		}
+		if t.age >= common.MAX_TREE_REUSE {
+			return badTree("block reuses a tree stored %d blocks before it, the limit is %d", t.age+1, common.MAX_TREE_REUSE)
+		}
+		raw.treeDump = t.dump
+	}
+	t.age++
+	return nil
+}
+
+// readBlock reads and decodes the next block of a stream of bytes, io.EOF is returned for the
+// empty block that ends the stream
+// r: The stream, positioned at a block header
+// stream: The header of the stream
+// tree: The dump of the last block that stored one, updated as the block is read
+// maxSize: The largest block to decode, at most MAX_BLOCK_SIZE
+func readBlock(r io.Reader, stream *common.StreamHeader, tree *sharedTree, maxSize uint32) ([]byte, error) {
+	raw, err := readRawBlock(r, stream, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := tree.share(raw); err != nil {
+		return nil, err
+	}
+	return decodeBlock(raw, stream)
+}
+
+// readSymbolBlock reads and decodes the next block of a stream as symbols, blocks of bytes are
+// widened. io.EOF is returned for the empty block that ends the stream.
+// r: The stream, positioned at a block header
+// stream: The header of the stream
+// tree: The dump of the last block that stored one, updated as the block is read
+// maxSize: The most symbols in a block to decode, at most MAX_BLOCK_SIZE
+func readSymbolBlock(r io.Reader, stream *common.StreamHeader, tree *sharedTree, maxSize uint32) ([]uint32, error) {
+	raw, err := readRawBlock(r, stream, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := tree.share(raw); err != nil {
+		return nil, err
+	}
+	if stream.Flags&common.FLAG_ALPHABET == 0 {
+		data, err := decodeBlock(raw, stream)
+		if err != nil {
+			return nil, err
+		}
+		symbols := make([]uint32, len(data))
+		for i, b := range data {
+			symbols[i] = uint32(b)
+		}
+		return symbols, nil
+	}
+
+	symbols := make([]uint32, raw.header.UncompressedSize)
+	if raw.header.BlockType == common.BLOCK_STORED {
+		for i := range symbols {
+			symbols[i] = common.StreamByteOrder.Uint32(raw.payload[4*i:])
+			if symbols[i] >= stream.AlphabetSize {
+				return nil, fmt.Errorf("stored symbol %d is outside the alphabet of %d symbols", symbols[i], stream.AlphabetSize)
+			}
+		}
+		return symbols, nil
+	}
+
+	lengths, err := readSymbolLengthDump(raw.treeDump, stream.AlphabetSize)
+	if err != nil {
+		return nil, err
+	}
+	treeRoot, err := buildSymbolTree(lengths, int(stream.MaxCodeLength))
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := newTableDecoder(treeRoot)
+	if err != nil {
+		return nil, err
+	}
+	for i := range symbols {
+		if symbols[i], err = decoder.decodeSymbol(raw.bits); err != nil {
+			return nil, err
+		}
+	}
+	return symbols, nil
+}
+
+// readRawBlock reads the next block of a stream and checks its header, io.EOF is returned for the
+// empty block that ends the stream. A block bigger than maxSize is rejected before its payload is
+// read or anything is allocated for its data.
+// r: The stream, positioned at a block header
+// stream: The header of the stream
+// maxSize: The most symbols in a block, at most MAX_BLOCK_SIZE
+func readRawBlock(r io.Reader, stream *common.StreamHeader, maxSize uint32) (*rawBlock, error) {
+	raw, err := readBlockTree(r, stream)
+	if err != nil {
+		return nil, err
+	}
+	if raw.header.UncompressedSize > maxSize {
+		return nil, fmt.Errorf("block of %d symbols is larger than the limit of %d", raw.header.UncompressedSize, maxSize)
+	}
+	if raw.payload, err = readSection(r, int64(raw.header.PayloadSize)); err != nil {
+		return nil, err
+	}
+	raw.bits = common.NewBitReader(bytes.NewReader(raw.payload))
+	return raw, nil
+}
+
+// readBlockTree reads the header and dump of the next block of a stream and checks them, leaving
+// the stream at the block's payload. io.EOF is returned for the empty block that ends the stream.
+// r: The stream, positioned at a block header
+// stream: The header of the stream
+func readBlockTree(r io.Reader, stream *common.StreamHeader) (*rawBlock, error) {
+	header, err := readBlockHeader(r, stream.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the tree dump and make sure neither it nor the header are corrupt
+	treeDump := make([]byte, header.TreeSize)
+	if _, err := io.ReadFull(r, treeDump); err != nil {
+		return nil, unexpected(err)
+	}
+	if checksummed(stream) {
+		if err := verifyChecksum(r, SECTION_BLOCK_HEADER, common.BlockChecksum(&header, stream.Version, treeDump)); err != nil {
+			return nil, err
+		}
+	}
+
+	// An empty block marks the end of the stream
+	if header.UncompressedSize == 0 {
+		return nil, io.EOF
+	}
+	if header.UncompressedSize > common.MAX_BLOCK_SIZE {
+		return nil, fmt.Errorf("block of %d symbols is larger than the limit of %d", header.UncompressedSize, common.MAX_BLOCK_SIZE)
+	}
+	if err := checkBlockType(&header, stream); err != nil {
+		return nil, err
+	}
+	return &rawBlock{header: header, treeDump: treeDump}, nil
+}
+
+// checkBlockType makes sure a block's type is known and that its sizes make sense for it
+// header: The block header
+// stream: The header of the stream
+func checkBlockType(header *common.BlockHeader, stream *common.StreamHeader) error {
+	switch header.BlockType {
+	case common.BLOCK_CODED:
+		return nil
+	case common.BLOCK_REUSED_TREE:
+		// Only blocks coded with a single table of byte code lengths have a tree to reuse
+		if stream.Flags&common.FLAG_CANONICAL_CODES == 0 || stream.Flags&(common.FLAG_ADAPTIVE|common.FLAG_LZ77|common.FLAG_BWT|common.FLAG_CONTEXT|common.FLAG_ALPHABET) != 0 {
+			return errors.New("block reuses a tree in a stream that can't share them")
+		}
+	case common.BLOCK_STORED:
+		size := uint64(header.UncompressedSize)
+		if stream.Flags&common.FLAG_ALPHABET != 0 {
+			size *= 4
+		}
+		if uint64(header.PayloadSize) != size {
+			return fmt.Errorf("stored block of %d symbols has a payload of %d bytes", header.UncompressedSize, header.PayloadSize)
+		}
+	default:
+		return fmt.Errorf("unsupported block type %d", header.BlockType)
+	}
+	if header.TreeSize != 0 {
+		return fmt.Errorf("block of type %d has a %d byte dump", header.BlockType, header.TreeSize)
+	}
+	return nil
+}
+
+// readSection reads the given number of bytes. The buffer grows as the bytes arrive, so a corrupt
+// size can't allocate more memory than the input holds.
+// r: The stream
+// n: The number of bytes to read
+func readSection(r io.Reader, n int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, n); err != nil {
+		return nil, unexpected(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBlock decodes a block of a stream of bytes
+// raw: The block
+// stream: The header of the stream
+func decodeBlock(raw *rawBlock, stream *common.StreamHeader) ([]byte, error) {
+	if stream.Flags&common.FLAG_ALPHABET != 0 {
+		return nil, fmt.Errorf("stream codes symbols from an alphabet of %d symbols, not bytes", stream.AlphabetSize)
+	}
+	if raw.header.BlockType == common.BLOCK_STORED {
+		return raw.payload, nil
+	}
+
+	block := make([]byte, raw.header.UncompressedSize)
+	bits := raw.bits
+	if stream.Flags&common.FLAG_ADAPTIVE != 0 {
+		return block, decodeAdaptive(bits, block)
+	}
+	if stream.Flags&common.FLAG_LZ77 != 0 {
+		return block, decodeLZ77(raw.treeDump, int(stream.MaxCodeLength), bits, block)
+	}
+	if stream.Flags&common.FLAG_BWT != 0 {
+		return block, decodeBWT(raw.treeDump, int(stream.MaxCodeLength), bits, block)
+	}
+	if stream.Flags&common.FLAG_CONTEXT != 0 {
+		return block, decodeContext(raw.treeDump, int(stream.MaxCodeLength), bits, block)
+	}
+
+	treeRoot, err := buildTree(raw.treeDump, stream)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := newTableDecoder(treeRoot)
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.decode(bits, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// buildTree builds a block's huffman tree from either a code length dump or a tree dump
+// dump: The dump that followed the block header
+// stream: The header of the stream
+func buildTree(dump []byte, stream *common.StreamHeader) (common.HuffNode, error) {
+	if stream.Flags&common.FLAG_CANONICAL_CODES != 0 {
+		return BuildHuffmanTreeFromCodeLengths(dump, int(stream.MaxCodeLength))
+	}
+	return BuildHuffmanTreeFromDump(dump)
+}
+
+// checksummed returns if a stream has checksums to verify
+// stream: The header of the stream
+func checksummed(stream *common.StreamHeader) bool {
+	return stream.Flags&common.FLAG_CHECKSUM != 0
+}
+
+// verifyChecksum reads a stored checksum from a stream and compares it with the computed one
+// r: The stream, positioned at the stored checksum
+// section: The section of the stream the checksum covers
+// computed: The checksum computed while decoding
+func verifyChecksum(r io.Reader, section string, computed uint32) error {
+	var stored uint32
+	if err := binary.Read(r, common.StreamByteOrder, &stored); err != nil {
+		return unexpected(err)
+	}
+	if stored != computed {
+		return &ChecksumError{Section: section, Stored: stored, Computed: computed}
+	}
+	return nil
+}
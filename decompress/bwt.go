@@ -0,0 +1,185 @@
+package decompress
+
+import (
+	"errors"
+
+	"io.whypeople/huffman/common"
+)
+
+// decodeBWT decodes a BWT block, which must decode to exactly len(out) bytes
+// dump: The block's dump, with the row of the original data, the number of coded symbols and
+// the code length dumps of its tables
+// maxCodeLength: The longest code allowed by the stream header, 0 if there's no limit
+// br: The bits to decode
+// out: The buffer to write the decoded block to
+func decodeBWT(dump []byte, maxCodeLength int, br *common.BitReader, out []byte) error {
+	origin, count, lengths, err := readBWTDump(dump)
+	if err != nil {
+		return err
+	}
+
+	// Run-length coding never makes data longer, so there can't be more symbols than bytes
+	if int64(origin) >= int64(len(out)) || count == 0 || int64(count) > int64(len(out)) {
+		return badTree("malformed BWT block dump")
+	}
+
+	tables := make([]*tableDecoder, len(lengths))
+	for i := range tables {
+		if tables[i], err = newCanonicalDecoder(lengths[i], maxCodeLength); err != nil {
+			return err
+		}
+	}
+
+	selectors, err := readSelectors(br, (int(count)+common.BWT_GROUP_SIZE-1)/common.BWT_GROUP_SIZE, len(tables))
+	if err != nil {
+		return err
+	}
+
+	// Decode the symbols back into move-to-front values, expanding runs of zeros
+	values := out[:0]
+	run, digit := 0, uint(0)
+	for i := 0; i < int(count); i++ {
+		symbol, err := tables[selectors[i/common.BWT_GROUP_SIZE]].decodeSymbol(br)
+		if err != nil {
+			return err
+		}
+		if symbol == common.RUN_A || symbol == common.RUN_B {
+			run += int(symbol+1) << digit
+			digit++
+			if run > len(out)-len(values) {
+				return errors.New("BWT block is longer than its header says")
+			}
+			continue
+		}
+
+		for ; run > 0; run-- {
+			values = append(values, 0)
+		}
+		digit = 0
+		if len(values) == len(out) {
+			return errors.New("BWT block is longer than its header says")
+		}
+		values = append(values, byte(symbol-1))
+	}
+	for ; run > 0; run-- {
+		values = append(values, 0)
+	}
+	if len(values) != len(out) {
+		return errors.New("BWT block is shorter than its header says")
+	}
+
+	last := undoMoveToFront(values)
+	undoBurrowsWheeler(last, int(origin), out)
+	return nil
+}
+
+// readBWTDump unpacks the dump of a BWT block. Returns the row of the original data, the number of
+// coded symbols and the code lengths of each table.
+// dump: The block's dump
+func readBWTDump(dump []byte) (uint32, uint32, [][]uint8, error) {
+	if len(dump) < 9 {
+		return 0, 0, nil, badTree("malformed BWT block dump")
+	}
+	origin := common.StreamByteOrder.Uint32(dump[0:])
+	count := common.StreamByteOrder.Uint32(dump[4:])
+	tableCount := int(dump[8])
+	if tableCount < common.MIN_BWT_TABLES || tableCount > common.MAX_BWT_TABLES {
+		return 0, 0, nil, badTree("malformed BWT block dump")
+	}
+
+	lengths := make([][]uint8, tableCount)
+	used := 9
+	for i := range lengths {
+		table, n, err := readCodeLengthDump(dump[used:], common.BWT_ALPHABET_SIZE)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		lengths[i] = table
+		used += n
+	}
+	if used != len(dump) {
+		return 0, 0, nil, badTree("malformed BWT block dump")
+	}
+	return origin, count, lengths, nil
+}
+
+// readSelectors reads the table of every group of symbols, which are move-to-front coded and
+// written in unary
+// br: The bits to decode
+// groups: The number of groups
+// tableCount: The number of tables
+func readSelectors(br *common.BitReader, groups int, tableCount int) ([]uint8, error) {
+	var order [common.MAX_BWT_TABLES]uint8
+	for i := range order {
+		order[i] = uint8(i)
+	}
+
+	selectors := make([]uint8, groups)
+	for g := range selectors {
+		j := 0
+		for {
+			bit, err := readBits(br, 1)
+			if err != nil {
+				return nil, err
+			}
+			if bit == 0 {
+				break
+			}
+			j++
+			if j == tableCount {
+				return nil, errors.New("invalid BWT table selector")
+			}
+		}
+		selector := order[j]
+		copy(order[1:j+1], order[:j])
+		order[0] = selector
+		selectors[g] = selector
+	}
+	return selectors, nil
+}
+
+// undoMoveToFront turns move-to-front values back into the bytes they stand for
+// values: The move-to-front values
+func undoMoveToFront(values []byte) []byte {
+	var order [common.ALPHABET_SIZE]byte
+	for i := range order {
+		order[i] = byte(i)
+	}
+
+	data := make([]byte, len(values))
+	for i, j := range values {
+		b := order[j]
+		copy(order[1:int(j)+1], order[:j])
+		order[0] = b
+		data[i] = b
+	}
+	return data
+}
+
+// undoBurrowsWheeler rebuilds data from the last byte of each of its sorted rotations. The rows
+// that start with each byte are in the same order as the rows that end with it, which links every
+// row to the row of the rotation that starts one byte later.
+// last: The last byte of each sorted rotation
+// origin: The row of the rotation that starts at the beginning of the data
+// out: The buffer to write the data to, as long as last
+func undoBurrowsWheeler(last []byte, origin int, out []byte) {
+	var starts [common.ALPHABET_SIZE]int
+	for _, b := range last {
+		starts[b]++
+	}
+	for i, sum := 0, 0; i < len(starts); i++ {
+		starts[i], sum = sum, sum+starts[i]
+	}
+
+	next := make([]int32, len(last))
+	for row, b := range last {
+		next[starts[b]] = int32(row)
+		starts[b]++
+	}
+
+	row := next[origin]
+	for i := range out {
+		out[i] = last[row]
+		row = next[row]
+	}
+}
@@ -0,0 +1,81 @@
+package decompress
+
+import (
+	"io.whypeople/huffman/common"
+)
+
+// decodeContext decodes a block whose bytes are coded with a table picked by the byte before them,
+// the first byte of the block is decoded as if it followed a 0
+// dump: The block's dump, with the number of tables, the table of every context and the code
+// length dumps of the tables
+// maxCodeLength: The longest code allowed by the stream header, 0 if there's no limit
+// br: The bits to decode
+// out: The buffer to write the decoded block to
+func decodeContext(dump []byte, maxCodeLength int, br *common.BitReader, out []byte) error {
+	clusters, lengths, err := readContextDump(dump)
+	if err != nil {
+		return err
+	}
+	tables := make([]*tableDecoder, len(lengths))
+	for i := range tables {
+		if tables[i], err = newCanonicalDecoder(lengths[i], maxCodeLength); err != nil {
+			return err
+		}
+	}
+	var contextMap [common.ALPHABET_SIZE]*tableDecoder
+	for context, cluster := range clusters {
+		contextMap[context] = tables[cluster]
+	}
+
+	prev := byte(0)
+	for i := range out {
+		symbol, err := contextMap[prev].decodeSymbol(br)
+		if err != nil {
+			return err
+		}
+		out[i] = byte(symbol)
+		prev = out[i]
+	}
+	return nil
+}
+
+// readContextDump unpacks the dump of a context block. Returns the table of every context and the
+// code lengths of each table.
+// dump: The block's dump
+func readContextDump(dump []byte) ([common.ALPHABET_SIZE]uint8, [][]uint8, error) {
+	var clusters [common.ALPHABET_SIZE]uint8
+	if len(dump) == 0 {
+		return clusters, nil, badTree("malformed context block dump")
+	}
+	lengths := make([][]uint8, int(dump[0])+1)
+	used := 1
+
+	// The context map is stored as runs of contexts that share a table
+	for context := 0; context < common.ALPHABET_SIZE; {
+		if used+2 > len(dump) {
+			return clusters, nil, badTree("context block dump ends early")
+		}
+		cluster, run := dump[used], int(dump[used+1])+1
+		used += 2
+		if int(cluster) >= len(lengths) || context+run > common.ALPHABET_SIZE {
+			return clusters, nil, badTree("malformed context block dump")
+		}
+		for ; run > 0; run-- {
+			clusters[context] = cluster
+			context++
+		}
+	}
+
+	for i := range lengths {
+		table, n, err := readCodeLengthDump(dump[used:], common.ALPHABET_SIZE)
+		if err != nil {
+			return clusters, nil, err
+		}
+		lengths[i] = table
+		used += n
+	}
+	if used != len(dump) {
+		return clusters, nil, badTree("malformed context block dump")
+	}
+	return clusters, lengths, nil
+}
@@ -0,0 +1,205 @@
+package decompress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"io.whypeople/huffman/common"
+)
+
+// Option configures a Decoder
+type Option func(*Decoder) error
+
+// Decoder decompresses files and streams with a fixed set of options. Everything else, like the
+// block size and how blocks are coded, is read from the stream.
+type Decoder struct {
+	concurrency int
+	memoryLimit int64 // 0 for no limit
+}
+
+// NewDecoder returns a Decoder. Without options it decodes the blocks of a file on a goroutine
+// for every CPU and accepts blocks up to MAX_BLOCK_SIZE.
+// opts: The options to apply, in order
+func NewDecoder(opts ...Option) (*Decoder, error) {
+	d := &Decoder{concurrency: runtime.NumCPU()}
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// WithConcurrency sets the most goroutines that decode blocks of a file at once
+// n: The number of goroutines, at least 1
+func WithConcurrency(n int) Option {
+	return func(d *Decoder) error {
+		if n < 1 {
+			return errors.New("concurrency must be at least 1")
+		}
+		d.concurrency = n
+		return nil
+	}
+}
+
+// WithMemoryLimit limits the memory decoding takes. Fewer blocks are decoded at once to stay
+// under the limit, and a block that wouldn't fit on its own is an error.
+// limit: The limit in bytes
+func WithMemoryLimit(limit int64) Option {
+	return func(d *Decoder) error {
+		if limit < 1 {
+			return errors.New("memory limit must be positive")
+		}
+		d.memoryLimit = limit
+		return nil
+	}
+}
+
+// NewReader returns a Reader that decompresses r with the Decoder's limits
+// r: The compressed stream
+func (d *Decoder) NewReader(r io.Reader) (*Reader, error) {
+	z, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	z.maxBlockSize = d.maxBlockSize()
+	return z, nil
+}
+
+// NewSymbolReader returns a SymbolReader that decompresses r with the Decoder's limits
+// r: The compressed stream
+func (d *Decoder) NewSymbolReader(r io.Reader) (*SymbolReader, error) {
+	s, err := NewSymbolReader(r)
+	if err != nil {
+		return nil, err
+	}
+	s.maxBlockSize = d.maxSymbolBlockSize()
+	return s, nil
+}
+
+// Decode decompresses everything read from src into dst
+// dst: The writer to write the decompressed data to
+// src: The compressed stream
+func (d *Decoder) Decode(dst io.Writer, src io.Reader) error {
+	return d.DecodeContext(context.Background(), dst, src)
+}
+
+// DecodeContext is like Decode but stops and returns ctx.Err() once ctx is done, the context is
+// checked between reads of src
+// ctx: The context that stops decompression
+// dst: The writer to write the decompressed data to
+// src: The compressed stream
+func (d *Decoder) DecodeContext(ctx context.Context, dst io.Writer, src io.Reader) error {
+	reader, err := d.NewReader(common.NewContextReader(ctx, src))
+	if err == nil {
+		_, err = io.Copy(dst, reader)
+	}
+
+	// Reading src fails once ctx is done, but the error may be wrapped on its way out
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// DecodeFile decompresses a file, the blocks of a stream with a block index are decoded concurrently
+// infile: The file to be decompressed
+// outfile: The file to write the decompressed data to
+func (d *Decoder) DecodeFile(infile *os.File, outfile *os.File) (*os.File, error) {
+	return d.DecodeFileContext(context.Background(), infile, outfile)
+}
+
+// DecodeFileContext is like DecodeFile but stops every goroutine and returns ctx.Err() once ctx is done
+// ctx: The context that stops decompression
+// infile: The file to be decompressed
+// outfile: The file to write the decompressed data to
+func (d *Decoder) DecodeFileContext(ctx context.Context, infile *os.File, outfile *os.File) (*os.File, error) {
+	// Make sure file pointers are valid
+	if infile == nil || outfile == nil {
+		return nil, errors.New("infile and outfile cannot be nil")
+	}
+	if err := d.decodeFile(ctx, infile, outfile); err != nil {
+		return nil, err
+	}
+	return outfile, nil
+}
+
+// VerifyFile fully decodes a compressed file or archive and throws the data away, returning the
+// first sign of corruption. The block index of a stream that has one is used to decode it, so the
+// index is checked against the blocks.
+// infile: The file to verify, positioned at its start
+func (d *Decoder) VerifyFile(infile *os.File) error {
+	if infile == nil {
+		return errors.New("infile cannot be nil")
+	}
+
+	if IsArchive(infile) {
+		info, err := infile.Stat()
+		if err != nil {
+			return err
+		}
+		archive, err := NewArchiveReader(infile, info.Size())
+		if err != nil {
+			return err
+		}
+		return archive.Verify()
+	}
+	return d.decodeFile(context.Background(), infile, io.Discard)
+}
+
+// decodeFile decompresses a file, splitting streams with a block index between the goroutines
+// ctx: The context that stops decompression
+// infile: The file to be decompressed
+// out: The writer to write the decompressed data to
+func (d *Decoder) decodeFile(ctx context.Context, infile *os.File, out io.Writer) error {
+	if d.concurrency > 1 {
+		blocks, err := readBlockIndex(infile)
+		if err != nil {
+			return err
+		}
+		if blocks != nil {
+			// Up to twice the goroutines have a block in flight, each holding its payload and
+			// its decoded data
+			largest := int64(0)
+			for _, entry := range blocks.entries {
+				if int64(entry.UncompressedSize) > largest {
+					largest = int64(entry.UncompressedSize)
+				}
+			}
+			concurrency := d.concurrency
+			if d.memoryLimit != 0 && largest != 0 {
+				if 2*largest > d.memoryLimit {
+					return fmt.Errorf("block of %d bytes needs more memory than the limit of %d", largest, d.memoryLimit)
+				}
+				if fit := int(d.memoryLimit / (4 * largest)); fit < concurrency {
+					concurrency = fit
+				}
+			}
+			if concurrency > 1 {
+				return decompressParallel(ctx, out, concurrency, blocks)
+			}
+		}
+	}
+
+	// Otherwise stream the file through a decompressing reader
+	return d.DecodeContext(ctx, out, infile)
+}
+
+// maxBlockSize returns the largest block a Reader can decode within the memory limit, the block
+// and its payload are held at once
+func (d *Decoder) maxBlockSize() uint32 {
+	if d.memoryLimit == 0 || d.memoryLimit/2 >= common.MAX_BLOCK_SIZE {
+		return common.MAX_BLOCK_SIZE
+	}
+	return uint32(d.memoryLimit / 2)
+}
+
+// maxSymbolBlockSize returns the most symbols in a block a SymbolReader can decode within the
+// memory limit, every symbol is decoded to 4 bytes
+func (d *Decoder) maxSymbolBlockSize() uint32 {
+	return d.maxBlockSize() / 4
+}
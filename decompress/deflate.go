@@ -0,0 +1,214 @@
+package decompress
+
+import (
+	"errors"
+	"io"
+
+	"io.whypeople/huffman/common"
+)
+
+// inflater decodes a DEFLATE stream a block at a time
+type inflater struct {
+	br             *common.BitReader
+	history        []byte // The end of the data decoded so far, which matches can copy from
+	final          bool   // Whether the last block has been decoded
+	fixedLiterals  *tableDecoder
+	fixedDistances *tableDecoder
+}
+
+// newInflater returns an inflater that reads a DEFLATE stream
+// br: The bits of the stream
+func newInflater(br *common.BitReader) *inflater {
+	return &inflater{br: br}
+}
+
+// reset gets the inflater ready for another DEFLATE stream that follows the last one
+func (f *inflater) reset() {
+	f.history = f.history[:0]
+	f.final = false
+}
+
+// nextBlock decodes the next block and returns its data, io.EOF is returned after the last block
+func (f *inflater) nextBlock() ([]byte, error) {
+	if f.final {
+		return nil, io.EOF
+	}
+	header, err := readBits(f.br, 3)
+	if err != nil {
+		return nil, err
+	}
+	f.final = header&1 == 1
+
+	// Blocks are decoded onto the end of the history so matches can reach back into it
+	var data []byte
+	switch header >> 1 {
+	case common.DEFLATE_STORED:
+		data, err = f.readStored(f.history)
+	case common.DEFLATE_FIXED:
+		if f.fixedLiterals == nil {
+			if f.fixedLiterals, err = newDeflateDecoder(common.FixedLiteralLengths(), common.MAX_DEFLATE_CODE_LENGTH); err != nil {
+				return nil, err
+			}
+			if f.fixedDistances, err = newDeflateDecoder(common.FixedDistanceLengths(), common.MAX_DEFLATE_CODE_LENGTH); err != nil {
+				return nil, err
+			}
+		}
+		data, err = decodeMatches(f.br, f.fixedLiterals, f.fixedDistances, f.history, -1)
+	case common.DEFLATE_DYNAMIC:
+		var literals, distances *tableDecoder
+		if literals, distances, err = f.readDynamicHeader(); err != nil {
+			return nil, err
+		}
+		data, err = decodeMatches(f.br, literals, distances, f.history, -1)
+	default:
+		return nil, errors.New("invalid DEFLATE block type")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block := make([]byte, len(data)-len(f.history))
+	copy(block, data[len(f.history):])
+
+	// Only the last window of data can be reached by later matches
+	if len(data) > common.MAX_LZ77_WINDOW {
+		data = data[len(data)-common.MAX_LZ77_WINDOW:]
+	}
+	f.history = append(f.history[:0], data...)
+	return block, nil
+}
+
+// readStored reads a stored block and appends it to out
+// out: The data decoded so far
+func (f *inflater) readStored(out []byte) ([]byte, error) {
+	f.br.AlignToByte()
+	length, err := readBits(f.br, 16)
+	if err != nil {
+		return nil, err
+	}
+	complement, err := readBits(f.br, 16)
+	if err != nil {
+		return nil, err
+	}
+	if uint16(length) != ^uint16(complement) {
+		return nil, errors.New("stored block length doesn't match its complement")
+	}
+
+	for i := uint32(0); i < length; i++ {
+		b, err := readBits(f.br, 8)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, byte(b))
+	}
+	return out, nil
+}
+
+// readDynamicHeader reads the code lengths at the start of a dynamic block and returns the
+// decoders for its literal/length and distance codes
+func (f *inflater) readDynamicHeader() (*tableDecoder, *tableDecoder, error) {
+	var counts [3]uint32
+	for i, n := range []uint{5, 5, 4} {
+		count, err := readBits(f.br, n)
+		if err != nil {
+			return nil, nil, err
+		}
+		counts[i] = count
+	}
+	literalCount := int(counts[0]) + common.FIRST_LENGTH_SYMBOL
+	distanceCount := int(counts[1]) + 1
+	codeLengthCount := int(counts[2]) + 4
+	if literalCount > common.LITERAL_LENGTH_ALPHABET_SIZE || distanceCount > common.DISTANCE_ALPHABET_SIZE {
+		return nil, nil, badTree("dynamic block has too many code lengths")
+	}
+
+	// The code lengths are coded with their own code
+	codeLengthLengths := make([]uint8, common.CODE_LENGTH_ALPHABET_SIZE)
+	for _, symbol := range common.CodeLengthOrder[:codeLengthCount] {
+		length, err := readBits(f.br, 3)
+		if err != nil {
+			return nil, nil, err
+		}
+		codeLengthLengths[symbol] = uint8(length)
+	}
+	codeLengths, err := newDeflateDecoder(codeLengthLengths, common.MAX_CODE_LENGTH_CODE_LENGTH)
+	if err != nil {
+		return nil, nil, err
+	}
+	if codeLengths == nil {
+		return nil, nil, badTree("dynamic block has no code length codes")
+	}
+
+	// Runs can carry on from the literal/length lengths into the distance lengths
+	lengths := make([]uint8, 0, literalCount+distanceCount)
+	for len(lengths) < literalCount+distanceCount {
+		symbol, err := codeLengths.decodeSymbol(f.br)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		length, run, extraBits := uint8(symbol), 1, uint(0)
+		switch symbol {
+		case common.REPEAT_PREVIOUS:
+			if len(lengths) == 0 {
+				return nil, nil, badTree("code length repeat with no previous length")
+			}
+			length, run, extraBits = lengths[len(lengths)-1], 3, 2
+		case common.REPEAT_ZERO:
+			length, run, extraBits = 0, 3, 3
+		case common.REPEAT_ZERO_LONG:
+			length, run, extraBits = 0, 11, 7
+		}
+		if symbol >= common.CODE_LENGTH_ALPHABET_SIZE {
+			return nil, nil, badTree("invalid code length symbol")
+		}
+		extra, err := readBits(f.br, extraBits)
+		if err != nil {
+			return nil, nil, err
+		}
+		run += int(extra)
+
+		if len(lengths)+run > literalCount+distanceCount {
+			return nil, nil, badTree("code length run is too long")
+		}
+		for i := 0; i < run; i++ {
+			lengths = append(lengths, length)
+		}
+	}
+
+	if lengths[common.END_OF_BLOCK] == 0 {
+		return nil, nil, badTree("dynamic block has no end of block code")
+	}
+	literals, err := newDeflateDecoder(lengths[:literalCount], common.MAX_DEFLATE_CODE_LENGTH)
+	if err != nil {
+		return nil, nil, err
+	}
+	distances, err := newDeflateDecoder(lengths[literalCount:], common.MAX_DEFLATE_CODE_LENGTH)
+	return literals, distances, err
+}
+
+// newDeflateDecoder builds a decoder for a DEFLATE code from its code lengths. Returns nil if no
+// symbol has a code. A lone symbol has a 1 bit code, the other bit pattern is given to a symbol
+// past the end of the alphabet so it's rejected when decoded.
+// lengths: The code length of every symbol
+// maxCodeLength: The longest code allowed
+func newDeflateDecoder(lengths []uint8, maxCodeLength int) (*tableDecoder, error) {
+	used := 0
+	for _, length := range lengths {
+		if length != 0 {
+			used++
+		}
+	}
+	switch used {
+	case 0:
+		return nil, nil
+	case 1:
+		lengths = append(lengths[:len(lengths):len(lengths)], 1)
+	}
+
+	treeRoot, err := buildCanonicalTree(lengths, maxCodeLength)
+	if err != nil {
+		return nil, err
+	}
+	return newTableDecoder(treeRoot)
+}
@@ -0,0 +1,44 @@
+package decompress
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrChecksumMismatch is matched by every ChecksumError with errors.Is
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Errors for malformed input, the errors that give more detail match them with errors.Is
+var ErrTruncated = errors.New("truncated input")     // The input ends part way through
+var ErrBadTree = errors.New("invalid huffman tree")  // A tree dump or code length dump can't be decoded
+var ErrBadMagic = errors.New("invalid magic number") // The input isn't a kind of file this build reads
+
+// The sections of a stream that are covered by a checksum
+const SECTION_BLOCK_HEADER = "block header"
+const SECTION_DATA = "data"
+const SECTION_BLOCK_INDEX = "block index"
+const SECTION_DIRECTORY = "central directory"
+
+// ChecksumError reports a section of a stream whose checksum didn't match
+type ChecksumError struct {
+	Section  string // The section that failed, one of the SECTION constants
+	Stored   uint32 // The checksum stored in the stream
+	Computed uint32 // The checksum computed while decoding
+}
+
+// Error returns a description of the mismatch
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s: %s stored 0x%08x, computed 0x%08x", ErrChecksumMismatch, e.Section, e.Stored, e.Computed)
+}
+
+// Unwrap lets errors.Is match ErrChecksumMismatch
+func (e *ChecksumError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// badTree returns an error matching ErrBadTree that says what's wrong with a tree
+// format: The description of the problem
+// args: The values for format
+func badTree(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrBadTree}, args...)...)
+}
@@ -0,0 +1,78 @@
+//go:build gofuzz
+// +build gofuzz
+
+package decompress
+
+import (
+	"bytes"
+	"io"
+
+	"io.whypeople/huffman/common"
+)
+
+// Fuzz targets for go-fuzz, built with go-fuzz-build -func FuzzTreeDump or -func FuzzDecode. They
+// return 1 for input that decodes cleanly, so go-fuzz favours it, and 0 otherwise. Anything that
+// panics is a bug.
+
+// The most data a fuzz target decodes from one input, a tiny stream can stand for far more data
+// than is worth decoding
+const FUZZ_OUTPUT_LIMIT = 1 << 22
+
+// FuzzTreeDump parses input as a tree dump and as a code length dump, and builds a decoder from
+// each tree it gets
+// data: The input from the fuzzer
+func FuzzTreeDump(data []byte) int {
+	score := 0
+	if treeRoot, err := BuildHuffmanTreeFromDump(data); err == nil {
+		if _, err := newTableDecoder(treeRoot); err == nil {
+			score = 1
+		}
+	}
+	if lengths, _, err := readCodeLengthDump(data, common.ALPHABET_SIZE); err == nil {
+		if _, err := newCanonicalDecoder(lengths, 0); err == nil {
+			score = 1
+		}
+	}
+	return score
+}
+
+// FuzzDecode decodes input as a compressed file, a stream of symbols and an archive, and inspects
+// its code tables
+// data: The input from the fuzzer
+func FuzzDecode(data []byte) int {
+	score := 0
+	if reader, err := NewReader(bytes.NewReader(data)); err == nil {
+		if _, err := io.CopyN(io.Discard, reader, FUZZ_OUTPUT_LIMIT); err == io.EOF {
+			score = 1
+		}
+	}
+
+	if reader, err := NewSymbolReader(bytes.NewReader(data)); err == nil {
+		symbols := make([]uint32, common.MAX_IO_BLOCK_SIZE)
+		for total := 0; total < FUZZ_OUTPUT_LIMIT; {
+			n, err := reader.ReadSymbols(symbols)
+			if err != nil {
+				break
+			}
+			total += n
+		}
+	}
+
+	// Inspecting gzip files decodes them, which the reader above already bounds
+	if len(data) < 2 || data[0] != common.GZIP_ID1 || data[1] != common.GZIP_ID2 {
+		Inspect(bytes.NewReader(data))
+	}
+
+	if archive, err := NewArchiveReader(bytes.NewReader(data), int64(len(data))); err == nil {
+		for i, entry := range archive.Entries() {
+			if entry.Type != common.ENTRY_FILE {
+				continue
+			}
+			if reader, err := archive.Open(i); err == nil {
+				io.CopyN(io.Discard, reader, FUZZ_OUTPUT_LIMIT)
+			}
+		}
+		score = 1
+	}
+	return score
+}
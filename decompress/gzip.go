@@ -0,0 +1,126 @@
+package decompress
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"io.whypeople/huffman/common"
+)
+
+// readGzipHeader reads the header of a gzip member and gets ready to inflate its data
+func (z *Reader) readGzipHeader() error {
+	if z.inflater == nil {
+		z.inflater = newInflater(common.NewBitReader(z.r))
+	} else {
+		z.inflater.reset()
+	}
+	br := z.inflater.br
+
+	header := common.GzipHeader{}
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return unexpected(err)
+	}
+	if header.ID1 != common.GZIP_ID1 || header.ID2 != common.GZIP_ID2 {
+		return fmt.Errorf("%w in a gzip member header", ErrBadMagic)
+	}
+	if header.CompressionMethod != common.GZIP_METHOD_DEFLATE {
+		return fmt.Errorf("unsupported gzip compression method %d", header.CompressionMethod)
+	}
+	if unknown := header.Flags &^ common.GZIP_KNOWN_FLAGS; unknown != 0 {
+		return fmt.Errorf("unsupported gzip flags %#x", unknown)
+	}
+
+	// Skip the optional fields, the header checksum isn't checked
+	if header.Flags&common.GZIP_FLAG_EXTRA != 0 {
+		var length uint16
+		if err := binary.Read(br, binary.LittleEndian, &length); err != nil {
+			return unexpected(err)
+		}
+		if err := skipBytes(br, int(length)); err != nil {
+			return err
+		}
+	}
+	for _, flag := range []uint8{common.GZIP_FLAG_NAME, common.GZIP_FLAG_COMMENT} {
+		if header.Flags&flag != 0 {
+			if err := skipString(br); err != nil {
+				return err
+			}
+		}
+	}
+	if header.Flags&common.GZIP_FLAG_HCRC != 0 {
+		if err := skipBytes(br, 2); err != nil {
+			return err
+		}
+	}
+
+	z.gzipDigest = crc32.NewIEEE()
+	z.gzipSize = 0
+	return nil
+}
+
+// nextGzipBlock inflates the next block of a gzip member, checking the member's trailer once it
+// ends and moving on to the next member if there is one
+func (z *Reader) nextGzipBlock() ([]byte, error) {
+	block, err := z.inflater.nextBlock()
+	if err == nil {
+		z.gzipDigest.Write(block)
+		z.gzipSize += uint32(len(block))
+		return block, nil
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+
+	// The trailer starts at the next byte boundary
+	br := z.inflater.br
+	br.AlignToByte()
+	trailer := common.GzipTrailer{}
+	if err := binary.Read(br, binary.LittleEndian, &trailer); err != nil {
+		return nil, unexpected(err)
+	}
+	if checksum := z.gzipDigest.Sum32(); checksum != trailer.DataChecksum {
+		return nil, &ChecksumError{Section: SECTION_DATA, Stored: trailer.DataChecksum, Computed: checksum}
+	}
+	if trailer.Size != z.gzipSize {
+		return nil, errors.New("gzip member size doesn't match its data")
+	}
+
+	// Members can be concatenated
+	br.Refill()
+	if br.Buffered() == 0 {
+		if br.Err() != io.EOF {
+			return nil, br.Err()
+		}
+		return nil, io.EOF
+	}
+	return nil, z.readGzipHeader()
+}
+
+// skipBytes reads and discards bytes
+// br: The bits to read, at a byte boundary
+// n: The number of bytes to skip
+func skipBytes(br *common.BitReader, n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := br.ReadByte(); err != nil {
+			return unexpected(err)
+		}
+	}
+	return nil
+}
+
+// skipString reads and discards a zero terminated string
+// br: The bits to read, at a byte boundary
+func skipString(br *common.BitReader) error {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return unexpected(err)
+		}
+		if b == 0 {
+			return nil
+		}
+	}
+}
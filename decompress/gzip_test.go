@@ -0,0 +1,104 @@
+package decompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"io.whypeople/huffman/common"
+)
+
+// The size of a gzip header without any optional fields
+const PLAIN_GZIP_HEADER_SIZE = 10
+
+// stdlibMember compresses data into a gzip member with the standard library
+// t: The test
+// data: The data to compress
+// level: The compression level
+// header: The optional header fields to set
+func stdlibMember(t *testing.T, data []byte, level int, header gzip.Header) []byte {
+	t.Helper()
+	var member bytes.Buffer
+	z, err := gzip.NewWriterLevel(&member, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.Header = header
+	if _, err := z.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return member.Bytes()
+}
+
+// readAll decompresses a stream with a Reader
+// t: The test
+// stream: The compressed stream
+func readAll(t *testing.T, stream []byte) []byte {
+	t.Helper()
+	z, err := NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestReaderDecodesStandardLibraryGzip(t *testing.T) {
+	text := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog, ", 2000))
+	noise := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(noise)
+	tests := []struct {
+		name      string
+		data      []byte
+		level     int
+		blockType uint8 // The type of the member's first DEFLATE block
+	}{
+		{"stored", text, gzip.NoCompression, common.DEFLATE_STORED},
+		{"fixed", []byte("abcabcabc"), gzip.BestCompression, common.DEFLATE_FIXED},
+		{"dynamic", text, gzip.DefaultCompression, common.DEFLATE_DYNAMIC},
+		{"huffman only", text, gzip.HuffmanOnly, common.DEFLATE_DYNAMIC},
+		{"noise", noise, gzip.BestSpeed, common.DEFLATE_STORED},
+		{"empty", nil, gzip.DefaultCompression, common.DEFLATE_FIXED},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			member := stdlibMember(t, test.data, test.level, gzip.Header{})
+			if blockType := member[PLAIN_GZIP_HEADER_SIZE] >> 1 & 3; blockType != test.blockType {
+				t.Fatalf("first block has type %d, expected %d", blockType, test.blockType)
+			}
+			if !bytes.Equal(readAll(t, member), test.data) {
+				t.Fatal("decoded data doesn't match")
+			}
+		})
+	}
+}
+
+func TestReaderDecodesGzipHeaderFields(t *testing.T) {
+	data := []byte("data after a header with every optional field")
+	header := gzip.Header{Name: "name.txt", Comment: "a comment", Extra: []byte{'A', 'B', 2, 0, 1, 2}}
+	if !bytes.Equal(readAll(t, stdlibMember(t, data, gzip.DefaultCompression, header)), data) {
+		t.Fatal("decoded data doesn't match")
+	}
+}
+
+func TestReaderDecodesGzipMembers(t *testing.T) {
+	first := []byte(strings.Repeat("first member ", 10000))
+	second := make([]byte, 50000)
+	rand.New(rand.NewSource(2)).Read(second)
+	stream := stdlibMember(t, first, gzip.BestCompression, gzip.Header{})
+	stream = append(stream, stdlibMember(t, second, gzip.NoCompression, gzip.Header{})...)
+	stream = append(stream, stdlibMember(t, nil, gzip.DefaultCompression, gzip.Header{})...)
+
+	if !bytes.Equal(readAll(t, stream), append(first, second...)) {
+		t.Fatal("decoded data doesn't match")
+	}
+}
@@ -1,8 +1,11 @@
 package decompress
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 
 	"io.whypeople/huffman/common"
@@ -13,101 +16,136 @@ import (
 // outfile: The file to write the decompressed data to
 // maxGoroutines: The maximum number of goroutines to use
 func DecompressFile(infile *os.File, outfile *os.File, maxGoroutines int) (*os.File, error) {
-	// Make sure file pointers are valid
-	if infile == nil || outfile == nil {
-		return nil, errors.New("infile and outfile cannot be nil")
-	}
+	return (&Decoder{concurrency: maxGoroutines}).DecodeFile(infile, outfile)
+}
 
-	// Read the header and validate magic number
-	header := readFileHeader(infile)
-	if header.MagicNumber != common.MAGIC_NUMBER {
-		return nil, errors.New("invalid magic number")
+// DecompressContext is like DecompressFile but stops every goroutine and returns ctx.Err() once ctx is done
+// ctx: The context that stops decompression
+// infile: The file to be decompressed
+// outfile: The file to write the decompressed data to
+// maxGoroutines: The maximum number of goroutines to use
+func DecompressContext(ctx context.Context, infile *os.File, outfile *os.File, maxGoroutines int) (*os.File, error) {
+	return (&Decoder{concurrency: maxGoroutines}).DecodeFileContext(ctx, infile, outfile)
+}
+
+// readBits reads an n bit value, least significant bit first
+// br: The bits to read
+// n: The number of bits to read, at most 32
+func readBits(br *common.BitReader, n uint) (uint32, error) {
+	value, err := br.ReadBits(n)
+	return uint32(value), unexpected(err)
+}
+
+// exhausted returns the error for running out of bits part way through a code
+// br: The bits that ran out
+func exhausted(br *common.BitReader) error {
+	if err := br.Err(); err != nil && err != io.EOF {
+		return err
 	}
+	return ErrTruncated
+}
 
-	// Read the tree dump
-	treeDump := make([]byte, header.TreeSize)
-	infile.Read(treeDump)
+// readFileHeader reads the header of a huffman encoded file with a single whole-file tree
+// infile: The file to read the header from
+// order: The byte order of the machine that wrote the file
+func readFileHeader(infile io.Reader, order binary.ByteOrder) (common.HuffHeader, error) {
+	// Read the header
+	header := common.HuffHeader{}
+	if err := binary.Read(infile, order, &header); err != nil {
+		return header, unexpected(err)
+	}
 
-	// Build the huffman tree from the tree dump
-	huffTreeRoot := BuildHuffmanTreeFromDump(treeDump)
+	// The sizes are checked before anything is allocated from them
+	if header.MagicNumber != common.MAGIC_NUMBER {
+		return header, ErrBadMagic
+	}
 
-	// Decompress the file
-	return decompress(infile, outfile, maxGoroutines, header, huffTreeRoot)
+	// Empty files have no tree, but were written with a tree size of -1 cast to a uint16
+	if header.OriginalFileSize == 0 {
+		header.TreeSize = 0
+	}
+	if header.TreeSize > common.MAX_TREE_SIZE {
+		return header, badTree("tree dump of %d bytes is larger than the %d byte limit", header.TreeSize, common.MAX_TREE_SIZE)
+	}
+	if header.OriginalFileSize < 0 {
+		return header, fmt.Errorf("invalid original file size %d", header.OriginalFileSize)
+	}
+	return header, nil
 }
 
-// TODO: Make this concurrent
-// decompress decompresses the infile and writes the decompressed data to outfile
-// infile: The file to be decompressed
-// outfile: The file to write the decompressed data to
-// maxGoroutines: The maximum number of goroutines to use
-// header: The header of the file to be decompressed
-// treeRoot: The root of the huffman tree
-func decompress(infile *os.File, outfile *os.File, maxGoroutines int, header common.HuffHeader, treeRoot common.HuffNode) (*os.File, error) {
-	var bitBuf common.BitVec
-
-	readBuf := make([]byte, common.MAX_IO_BLOCK_SIZE)
-	outBuf := make([]byte, common.MAX_IO_BLOCK_SIZE)
-	symbolsDecoded := int64(0)
-	navNode := treeRoot
-	bitBufPtr := -1
-
-	// Read the compressed data
-	for symbolsDecoded < header.OriginalFileSize {
-		// Read data if needed
-		if bitBufPtr == -1 || bitBufPtr == common.MAX_BIT_BUFFER_SIZE {
-			_, err := infile.Read(readBuf)
-			if err != nil {
-				return nil, err
-			}
-			bitBuf = common.NewVectorFromData(readBuf)
-			bitBufPtr = 0
-		}
+// readLegacyTree reads the header and tree of a file with a single whole-file tree, the tree is
+// nil for empty files which don't have one
+// infile: The file to read the header and tree from
+// order: The byte order of the machine that wrote the file
+func readLegacyTree(infile io.Reader, order binary.ByteOrder) (common.HuffHeader, common.HuffNode, error) {
+	header, err := readFileHeader(infile, order)
+	if err != nil || header.OriginalFileSize == 0 {
+		return header, nil, err
+	}
 
-		// Read the next bit and update nav node
-		if bitBuf.GetBit(bitBufPtr) {
-			navNode = navNode.Right()
-		} else {
-			navNode = navNode.Left()
-		}
-		bitBufPtr++
+	// Read the tree dump and build the huffman tree
+	treeDump := make([]byte, header.TreeSize)
+	if _, err := io.ReadFull(infile, treeDump); err != nil {
+		return header, nil, unexpected(err)
+	}
+	treeRoot, err := BuildHuffmanTreeFromDump(treeDump)
+	return header, treeRoot, err
+}
 
-		// navNode is not a symbol if it isn't a leaf
-		if !navNode.IsLeaf() {
-			continue
-		}
+// readStreamHeader reads the header of a block based stream and checks this build can decode it
+// infile: The stream to read the header from
+func readStreamHeader(infile io.Reader) (common.StreamHeader, error) {
+	header := common.StreamHeader{}
+	if err := readFields(infile, header.Fields()...); err != nil {
+		return header, err
+	}
+	if header.Version == 0 || header.Version > common.FORMAT_VERSION {
+		return header, fmt.Errorf("unsupported format version %d, this build reads up to version %d", header.Version, common.FORMAT_VERSION)
+	}
 
-		// Write the symbol
-		outBuf[symbolsDecoded % common.MAX_IO_BLOCK_SIZE] = navNode.Data().Symbol
-
-		// Write the outbuffer if we have filled it
-		if symbolsDecoded % common.MAX_IO_BLOCK_SIZE == common.MAX_IO_BLOCK_SIZE - 1 {
-			_, err := outfile.Write(outBuf)
-			if err != nil {
-				return nil, err
-			}
-			// Reset outBuf
-			outBuf = make([]byte, common.MAX_IO_BLOCK_SIZE)
-		}
+	// Which fields come next depends on the version and flags just read
+	if err := readFields(infile, header.Fields()[3:]...); err != nil {
+		return header, err
+	}
+	if unknown := header.Flags &^ common.KNOWN_FLAGS; unknown != 0 {
+		return header, fmt.Errorf("unsupported feature flags %#x", unknown)
+	}
 
-		// Reset the navigation node
-		navNode = treeRoot
-		symbolsDecoded++
+	// Streams of other alphabets only use plain canonical codes
+	if header.Flags&common.FLAG_ALPHABET == 0 {
+		header.AlphabetSize = common.ALPHABET_SIZE
+	} else if header.AlphabetSize == 0 || header.Flags&common.FLAG_CANONICAL_CODES == 0 || header.Flags&(common.FLAG_ADAPTIVE|common.FLAG_LZ77|common.FLAG_BWT|common.FLAG_CONTEXT) != 0 {
+		return header, errors.New("invalid alphabet stream header")
 	}
+	return header, nil
+}
 
-	// Write the remaining data
-	_, err := outfile.Write(outBuf[:symbolsDecoded % common.MAX_IO_BLOCK_SIZE])
-	if err != nil {
-		return nil, err
+// readFields reads fixed size fields from a stream one after the other
+// infile: The stream to read the fields from
+// fields: Pointers to the fields to read
+func readFields(infile io.Reader, fields ...interface{}) error {
+	for _, field := range fields {
+		if err := binary.Read(infile, common.StreamByteOrder, field); err != nil {
+			return unexpected(err)
+		}
 	}
+	return nil
+}
 
-	return outfile, nil
+// readBlockHeader reads the header of a block in a huffman encoded stream
+// infile: The stream to read the header from
+// version: The version of the stream, which decides the fields of the header
+func readBlockHeader(infile io.Reader, version uint8) (common.BlockHeader, error) {
+	header := common.BlockHeader{}
+	err := readFields(infile, header.Fields(version)...)
+	return header, err
 }
 
-// readFileHeader reads the header of a huffman encoded file
-// infile: The file to read the header from
-func readFileHeader(infile *os.File) common.HuffHeader {
-	// Read the header
-	header := common.HuffHeader{}
-	binary.Read(infile, common.Endianess(), &header)
-	return header
-}
\ No newline at end of file
+// unexpected converts running out of input into ErrTruncated for reads that must succeed
+// err: The error to convert
+func unexpected(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
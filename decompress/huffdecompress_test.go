@@ -0,0 +1,88 @@
+package decompress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"io.whypeople/huffman/common"
+)
+
+// legacyEmptyFile returns an empty file as the whole-file tree format wrote it, with a tree size
+// of -1 cast to a uint16
+// t: The test
+// order: The byte order of the machine that wrote the file
+func legacyEmptyFile(t *testing.T, order binary.ByteOrder) []byte {
+	t.Helper()
+	var file bytes.Buffer
+	header := common.HuffHeader{MagicNumber: common.MAGIC_NUMBER, TreeSize: 0xFFFF, OriginalFileSize: 0}
+	if err := binary.Write(&file, order, &header); err != nil {
+		t.Fatal(err)
+	}
+	return file.Bytes()
+}
+
+func TestLegacyEmptyFile(t *testing.T) {
+	orders := []struct {
+		name  string
+		order binary.ByteOrder
+	}{
+		{"little endian", binary.LittleEndian},
+		{"big endian", binary.BigEndian},
+	}
+	for _, o := range orders {
+		t.Run(o.name, func(t *testing.T) {
+			file := legacyEmptyFile(t, o.order)
+			if decoded := readAll(t, file); len(decoded) != 0 {
+				t.Fatalf("decoded %d bytes from an empty file", len(decoded))
+			}
+
+			dir := t.TempDir()
+			inPath, outPath := filepath.Join(dir, "empty.huf"), filepath.Join(dir, "empty")
+			if err := os.WriteFile(inPath, file, 0600); err != nil {
+				t.Fatal(err)
+			}
+			infile, err := os.Open(inPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer infile.Close()
+			outfile, err := os.Create(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer outfile.Close()
+			if _, err := DecompressFile(infile, outfile, 4); err != nil {
+				t.Fatal(err)
+			}
+			if info, err := os.Stat(outPath); err != nil || info.Size() != 0 {
+				t.Fatalf("decompressed an empty file to %v, %v", info, err)
+			}
+
+			if _, err := infile.Seek(0, 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyFile(infile, 4); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLegacyFileTruncatedAfterHeader(t *testing.T) {
+	// A file that isn't empty has to have its tree
+	var file bytes.Buffer
+	header := common.HuffHeader{MagicNumber: common.MAGIC_NUMBER, TreeSize: 3, OriginalFileSize: 10}
+	if err := binary.Write(&file, binary.LittleEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewReader(bytes.NewReader(file.Bytes()))
+	if err == nil {
+		_, err = z.Read(make([]byte, 10))
+	}
+	if err == nil {
+		t.Fatal("a file without its tree decoded")
+	}
+}
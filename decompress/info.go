@@ -0,0 +1,258 @@
+package decompress
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"io.whypeople/huffman/common"
+)
+
+// The kinds of compressed file Inspect recognises
+const FORMAT_LEGACY = "legacy" // A file with a single whole-file tree
+const FORMAT_STREAM = "stream" // A block based stream
+const FORMAT_GZIP = "gzip"
+
+// StreamInfo describes a compressed file, gathered from its headers and code tables
+type StreamInfo struct {
+	Format         string
+	MagicNumber    uint32
+	Version        uint8  // The layout version of a block based stream, 0 for other formats
+	Flags          uint8  // The feature flags of a block based stream
+	MaxCodeLength  uint8  // The longest code a block based stream allows, 0 if there's no limit
+	AlphabetSize   uint32 // The number of symbols in the alphabet of the original data
+	Blocks         int    // The number of blocks that hold data
+	ReusedTrees    int    // The number of blocks coded with the tree of an earlier block
+	StoredBlocks   int    // The number of blocks stored without being coded
+	TreeSize       uint64 // The total size of every tree dump or code length dump
+	OriginalSize   uint64 // The size of the data once decoded (in symbols with FLAG_ALPHABET)
+	CompressedSize int64
+	Symbols        int      // The number of different symbols given a code by any table
+	CodeLengths    []uint64 // CodeLengths[n] is the number of n bit codes over every table of every block
+}
+
+// Ratio returns the original size divided by the compressed size
+func (info *StreamInfo) Ratio() float64 {
+	if info.CompressedSize == 0 {
+		return 0
+	}
+	return float64(info.OriginalSize) / float64(info.CompressedSize)
+}
+
+// Inspect reads a compressed file's headers and code tables without decoding its data. Symbols
+// and code lengths are counted in the alphabet blocks are coded in, so BWT streams count their
+// run and move-to-front symbols and LZ77 streams count literal/length codes but not distance
+// codes. Gzip files have to be decoded to find their size, and their code tables aren't counted.
+// r: The compressed file
+func Inspect(r io.Reader) (*StreamInfo, error) {
+	counter := &common.CountingReader{R: r}
+	in := bufio.NewReader(counter)
+	magic, err := in.Peek(4)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+
+	info := &StreamInfo{AlphabetSize: common.ALPHABET_SIZE}
+	seen := make(map[uint32]bool)
+	switch {
+	case magic[0] == common.GZIP_ID1 && magic[1] == common.GZIP_ID2:
+		info.Format = FORMAT_GZIP
+		info.MagicNumber = uint32(binary.BigEndian.Uint16(magic))
+		err = inspectGzip(in, info)
+	case common.StreamByteOrder.Uint32(magic) == common.STREAM_MAGIC_NUMBER:
+		info.Format = FORMAT_STREAM
+		err = inspectStream(in, info, seen)
+	case binary.LittleEndian.Uint32(magic) == common.MAGIC_NUMBER:
+		info.Format = FORMAT_LEGACY
+		err = inspectLegacy(in, binary.LittleEndian, info, seen)
+	case binary.BigEndian.Uint32(magic) == common.MAGIC_NUMBER:
+		info.Format = FORMAT_LEGACY
+		err = inspectLegacy(in, binary.BigEndian, info, seen)
+	default:
+		err = ErrBadMagic
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Whatever follows the last block, like the trailer and block index, still counts
+	if _, err := io.Copy(io.Discard, in); err != nil {
+		return nil, err
+	}
+	info.CompressedSize = counter.N
+	info.Symbols = len(seen)
+	return info, nil
+}
+
+// inspectLegacy reads the header and tree of a file with a single whole-file tree
+// in: The file, positioned at its header
+// order: The byte order the file was written in
+// info: The info to fill in
+// seen: The symbols that have a code so far
+func inspectLegacy(in io.Reader, order binary.ByteOrder, info *StreamInfo, seen map[uint32]bool) error {
+	header, treeRoot, err := readLegacyTree(in, order)
+	if err != nil {
+		return err
+	}
+	info.MagicNumber = header.MagicNumber
+	info.TreeSize = uint64(header.TreeSize)
+	info.OriginalSize = uint64(header.OriginalFileSize)
+
+	// Empty files have no tree
+	if treeRoot == nil {
+		return nil
+	}
+	info.Blocks = 1
+	lengths := make([]common.SymbolLength, 0)
+	treeCodeLengths(treeRoot, 0, &lengths)
+	info.addTable(lengths, seen)
+	return nil
+}
+
+// inspectStream reads the header and the code tables of every block of a block based stream
+// in: The stream, positioned at its header
+// info: The info to fill in
+// seen: The symbols that have a code so far
+func inspectStream(in io.Reader, info *StreamInfo, seen map[uint32]bool) error {
+	header, err := readStreamHeader(in)
+	if err != nil {
+		return err
+	}
+	info.MagicNumber = header.MagicNumber
+	info.Version = header.Version
+	info.Flags = header.Flags
+	info.MaxCodeLength = header.MaxCodeLength
+	info.AlphabetSize = header.AlphabetSize
+
+	for {
+		raw, err := readRawBlock(in, &header, common.MAX_BLOCK_SIZE)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		info.Blocks++
+		info.TreeSize += uint64(raw.header.TreeSize)
+		info.OriginalSize += uint64(raw.header.UncompressedSize)
+
+		// Only blocks that store their own dump add tables
+		switch raw.header.BlockType {
+		case common.BLOCK_REUSED_TREE:
+			info.ReusedTrees++
+			continue
+		case common.BLOCK_STORED:
+			info.StoredBlocks++
+			continue
+		}
+		tables, err := blockTables(raw.treeDump, &header)
+		if err != nil {
+			return err
+		}
+		for _, lengths := range tables {
+			info.addTable(lengths, seen)
+		}
+	}
+}
+
+// inspectGzip decodes a gzip file to find its size
+// in: The file, positioned at its first member
+// info: The info to fill in
+func inspectGzip(in io.Reader, info *StreamInfo) error {
+	reader, err := NewReader(in)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(io.Discard, reader)
+	info.OriginalSize = uint64(size)
+	return err
+}
+
+// blockTables returns the code lengths of every table in a block's dump, LZ77 blocks only return
+// their literal/length table and adaptive blocks have none
+// dump: The dump that followed the block header
+// stream: The header of the stream
+func blockTables(dump []byte, stream *common.StreamHeader) ([][]common.SymbolLength, error) {
+	switch {
+	case stream.Flags&common.FLAG_ADAPTIVE != 0:
+		return nil, nil
+	case stream.Flags&common.FLAG_ALPHABET != 0:
+		lengths, err := readSymbolLengthDump(dump, stream.AlphabetSize)
+		return [][]common.SymbolLength{lengths}, err
+	case stream.Flags&common.FLAG_LZ77 != 0:
+		lengths, _, err := readCodeLengthDump(dump, common.LITERAL_LENGTH_ALPHABET_SIZE)
+		return sparseTables([][]uint8{lengths}), err
+	case stream.Flags&common.FLAG_BWT != 0:
+		_, _, lengths, err := readBWTDump(dump)
+		return sparseTables(lengths), err
+	case stream.Flags&common.FLAG_CONTEXT != 0:
+		_, lengths, err := readContextDump(dump)
+		return sparseTables(lengths), err
+	case stream.Flags&common.FLAG_CANONICAL_CODES != 0:
+		lengths, _, err := readCodeLengthDump(dump, common.ALPHABET_SIZE)
+		return sparseTables([][]uint8{lengths}), err
+	}
+	treeRoot, err := BuildHuffmanTreeFromDump(dump)
+	if err != nil {
+		return nil, err
+	}
+	lengths := make([]common.SymbolLength, 0)
+	treeCodeLengths(treeRoot, 0, &lengths)
+	return [][]common.SymbolLength{lengths}, nil
+}
+
+// sparseTables keeps only the symbols that have a code in each of a set of tables
+// tables: The code length of every symbol of each table
+func sparseTables(tables [][]uint8) [][]common.SymbolLength {
+	sparse := make([][]common.SymbolLength, len(tables))
+	for i, lengths := range tables {
+		sparse[i] = common.SparseCodeLengths(lengths)
+	}
+	return sparse
+}
+
+// treeCodeLengths collects the depth of every leaf of a huffman tree
+// n: The current node
+// depth: The depth of the current node
+// lengths: The leaves found so far
+func treeCodeLengths(n common.HuffNode, depth uint8, lengths *[]common.SymbolLength) {
+	if n.IsLeaf() {
+		*lengths = append(*lengths, common.SymbolLength{Symbol: n.Data().Symbol, Length: depth})
+		return
+	}
+	treeCodeLengths(n.Left(), depth+1, lengths)
+	treeCodeLengths(n.Right(), depth+1, lengths)
+}
+
+// addTable counts the codes of a table
+// lengths: The symbols that have a code and their code lengths
+// seen: The symbols that have a code so far
+func (info *StreamInfo) addTable(lengths []common.SymbolLength, seen map[uint32]bool) {
+	for _, l := range lengths {
+		for int(l.Length) >= len(info.CodeLengths) {
+			info.CodeLengths = append(info.CodeLengths, 0)
+		}
+		info.CodeLengths[l.Length]++
+		seen[l.Symbol] = true
+	}
+}
+
+// VerifyFile fully decodes a compressed file or archive and throws the data away, returning the
+// first sign of corruption. The block index of a stream that has one is used to decode it, so the
+// index is checked against the blocks.
+// infile: The file to verify, positioned at its start
+// maxGoroutines: The maximum number of goroutines to use
+func VerifyFile(infile *os.File, maxGoroutines int) error {
+	return (&Decoder{concurrency: maxGoroutines}).VerifyFile(infile)
+}
+
+// IsArchive returns if a file starts with the magic number of an archive
+// r: The file
+func IsArchive(r io.ReaderAt) bool {
+	var magic [4]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return false
+	}
+	return common.StreamByteOrder.Uint32(magic[:]) == common.ARCHIVE_MAGIC_NUMBER
+}
@@ -0,0 +1,125 @@
+package decompress
+
+import (
+	"errors"
+
+	"io.whypeople/huffman/common"
+)
+
+// decodeLZ77 decodes an LZ77 block, which must decode to exactly len(out) bytes
+// dump: The code length dumps of the literal/length and distance alphabets
+// maxCodeLength: The longest code allowed by the stream header, 0 if there's no limit
+// br: The bits to decode
+// out: The buffer to write the decoded block to
+func decodeLZ77(dump []byte, maxCodeLength int, br *common.BitReader, out []byte) error {
+	literals, used, err := readCanonicalDecoder(dump, common.LITERAL_LENGTH_ALPHABET_SIZE, maxCodeLength)
+	if err != nil {
+		return err
+	}
+	distances, distanceUsed, err := readCanonicalDecoder(dump[used:], common.DISTANCE_ALPHABET_SIZE, maxCodeLength)
+	if err != nil {
+		return err
+	}
+	if used+distanceUsed != len(dump) {
+		return badTree("malformed code length dumps")
+	}
+
+	decoded, err := decodeMatches(br, literals, distances, out[:0], len(out))
+	if err != nil {
+		return err
+	}
+	if len(decoded) != len(out) {
+		return errors.New("LZ77 block is shorter than its header says")
+	}
+	return nil
+}
+
+// decodeMatches decodes literal/length and distance symbols up to the end of block symbol and
+// appends the data they stand for to out, matches can copy from anything already in out
+// br: The bits to decode
+// literals: The decoder for the literal/length alphabet
+// distances: The decoder for the distance alphabet, nil if the block has no distance codes
+// out: The data decoded so far
+// limit: The most data out may hold, -1 if there's no limit
+func decodeMatches(br *common.BitReader, literals *tableDecoder, distances *tableDecoder, out []byte, limit int) ([]byte, error) {
+	for {
+		symbol, err := literals.decodeSymbol(br)
+		if err != nil {
+			return nil, err
+		}
+		if symbol == common.END_OF_BLOCK {
+			return out, nil
+		}
+		if symbol >= common.LITERAL_LENGTH_ALPHABET_SIZE {
+			return nil, errors.New("invalid literal/length symbol")
+		}
+		if symbol < common.END_OF_BLOCK {
+			if len(out) == limit {
+				return nil, errors.New("block is longer than its header says")
+			}
+			out = append(out, byte(symbol))
+			continue
+		}
+
+		// A match is a length symbol and a distance symbol, each followed by extra bits
+		index := symbol - common.FIRST_LENGTH_SYMBOL
+		extra, err := readBits(br, uint(common.LengthExtraBits[index]))
+		if err != nil {
+			return nil, err
+		}
+		length := int(common.LengthBase[index]) + int(extra)
+
+		if distances == nil {
+			return nil, errors.New("match in a block with no distance codes")
+		}
+		symbol, err = distances.decodeSymbol(br)
+		if err != nil {
+			return nil, err
+		}
+		if symbol >= common.DISTANCE_ALPHABET_SIZE {
+			return nil, errors.New("invalid distance symbol")
+		}
+		extra, err = readBits(br, uint(common.DistanceExtraBits[symbol]))
+		if err != nil {
+			return nil, err
+		}
+		distance := int(common.DistanceBase[symbol]) + int(extra)
+
+		if distance > len(out) {
+			return nil, errors.New("match starts before the data")
+		}
+		if limit >= 0 && length > limit-len(out) {
+			return nil, errors.New("block is longer than its header says")
+		}
+
+		// Matches can overlap the data they produce, so they're copied a byte at a time
+		for i := 0; i < length; i++ {
+			out = append(out, out[len(out)-distance])
+		}
+	}
+}
+
+// readCanonicalDecoder reads a code length dump and builds a decoder for its canonical code,
+// returning the decoder and the number of bytes of the dump it took up
+// dump: The code length dump, it can be followed by other data
+// alphabetSize: The number of symbols in the alphabet
+// maxCodeLength: The longest code allowed by the stream header, 0 if there's no limit
+func readCanonicalDecoder(dump []byte, alphabetSize int, maxCodeLength int) (*tableDecoder, int, error) {
+	lengths, used, err := readCodeLengthDump(dump, alphabetSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	decoder, err := newCanonicalDecoder(lengths, maxCodeLength)
+	return decoder, used, err
+}
+
+// newCanonicalDecoder builds the decoder of a canonical code from its code lengths
+// lengths: The code length of every symbol in the alphabet
+// maxCodeLength: The longest code allowed by the stream header, 0 if there's no limit
+func newCanonicalDecoder(lengths []uint8, maxCodeLength int) (*tableDecoder, error) {
+	treeRoot, err := buildCanonicalTree(lengths, maxCodeLength)
+	if err != nil {
+		return nil, err
+	}
+	return newTableDecoder(treeRoot)
+}
@@ -0,0 +1,251 @@
+package decompress
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"io.whypeople/huffman/common"
+)
+
+// The block index of a stream, and where to find it
+type blockIndex struct {
+	stream  *io.SectionReader // The compressed stream, from its header to the end of the file
+	header  common.StreamHeader
+	entries []common.IndexEntry
+	offset  int64 // Where the index entries start in the stream
+}
+
+// readBlockIndex reads the header and block index of a stream that can be decoded in parallel.
+// Returns a nil index, without consuming any input, if the file isn't seekable or has no index.
+// infile: The compressed file, positioned at the start of the stream
+func readBlockIndex(infile *os.File) (*blockIndex, error) {
+	start, err := infile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil
+	}
+	end, err := infile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, nil
+	}
+	if _, err := infile.Seek(start, io.SeekStart); err != nil {
+		return nil, nil
+	}
+	return readStreamIndex(io.NewSectionReader(infile, start, end-start))
+}
+
+// readStreamIndex reads the header and block index of a stream that can be read at any offset.
+// Returns a nil index if the stream has no index.
+// stream: The compressed stream, from its header to its end
+func readStreamIndex(stream *io.SectionReader) (*blockIndex, error) {
+	size := stream.Size()
+
+	// Only block based streams with an index can be split up
+	var magic uint32
+	if err := binary.Read(io.NewSectionReader(stream, 0, size), common.StreamByteOrder, &magic); err != nil || magic != common.STREAM_MAGIC_NUMBER {
+		return nil, nil
+	}
+	header, err := readStreamHeader(io.NewSectionReader(stream, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if header.Flags&common.FLAG_BLOCK_INDEX == 0 {
+		return nil, nil
+	}
+
+	// The footer is at the very end of the stream, with the index entries just before it
+	footer := common.IndexFooter{}
+	footerSize := int64(binary.Size(footer))
+	if size < footerSize {
+		return nil, ErrTruncated
+	}
+	if err := binary.Read(io.NewSectionReader(stream, size-footerSize, footerSize), common.StreamByteOrder, &footer); err != nil {
+		return nil, unexpected(err)
+	}
+	if footer.MagicNumber != common.INDEX_MAGIC_NUMBER {
+		return nil, fmt.Errorf("%w in the block index footer", ErrBadMagic)
+	}
+
+	indexSize := int64(footer.BlockCount) * int64(binary.Size(common.IndexEntry{}))
+	if indexSize > size-footerSize {
+		return nil, errors.New("block index is larger than the stream")
+	}
+	index := make([]common.IndexEntry, footer.BlockCount)
+	if err := binary.Read(io.NewSectionReader(stream, size-footerSize-indexSize, indexSize), common.StreamByteOrder, index); err != nil {
+		return nil, unexpected(err)
+	}
+	if checksum := common.IndexChecksum(index); checksum != footer.IndexChecksum {
+		return nil, &ChecksumError{Section: SECTION_BLOCK_INDEX, Stored: footer.IndexChecksum, Computed: checksum}
+	}
+	return &blockIndex{stream, header, index, size - footerSize - indexSize}, nil
+}
+
+// The result of decoding one block
+type decodedBlock struct {
+	data []byte
+	err  error
+}
+
+// decompressParallel decodes the blocks of a stream on maxGoroutines workers and writes them in
+// order. Every goroutine has stopped by the time it returns, even if ctx is done or a block fails.
+// ctx: Stops the workers and returns ctx.Err() once it's done
+// out: The writer to write the decompressed data to
+// maxGoroutines: The maximum number of goroutines to use
+// blocks: The stream's block index
+func decompressParallel(ctx context.Context, out io.Writer, maxGoroutines int, blocks *blockIndex) error {
+	header, index := &blocks.header, blocks.entries
+
+	// Every block gets a buffered channel for its result. Blocks are handed out in order and only
+	// 2 * maxGoroutines may be waiting to be written at once.
+	results := make([]chan decodedBlock, len(index))
+	for i := range results {
+		results[i] = make(chan decodedBlock, 1)
+	}
+	jobs := make(chan int)
+	inFlight := make(chan struct{}, 2*maxGoroutines)
+
+	// Returning cancels the goroutines and waits for them
+	ctx, cancel := context.WithCancel(ctx)
+	var running sync.WaitGroup
+	defer running.Wait()
+	defer cancel()
+
+	// Hand out the blocks in order
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer close(jobs)
+		for i := range index {
+			select {
+			case inFlight <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Each worker decodes whole blocks, which don't depend on each other
+	decodeJob := func() {
+		defer running.Done()
+		for i := range jobs {
+			if err := ctx.Err(); err != nil {
+				results[i] <- decodedBlock{err: err}
+				continue
+			}
+			results[i] <- decodeIndexedBlock(blocks.stream, header, index, i)
+		}
+	}
+	running.Add(maxGoroutines)
+	for i := 0; i < maxGoroutines; i++ {
+		go decodeJob()
+	}
+
+	// Write the blocks in order
+	digest := common.NewChecksum()
+	for i := range index {
+		var result decodedBlock
+		select {
+		case result = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-inFlight
+		if result.err != nil {
+			return result.err
+		}
+		if _, err := out.Write(result.data); err != nil {
+			return err
+		}
+		digest.Write(result.data)
+	}
+
+	// The checksum of the data is stored just before the index
+	if !checksummed(header) {
+		return nil
+	}
+	if blocks.offset < 4 {
+		return ErrTruncated
+	}
+	return verifyChecksum(io.NewSectionReader(blocks.stream, blocks.offset-4, 4), SECTION_DATA, digest.Sum32())
+}
+
+// decodeIndexedBlock reads and decodes the block an index entry points at
+// stream: The compressed stream
+// header: The header of the stream
+// index: The entries of the block index
+// i: The index entry of the block
+func decodeIndexedBlock(stream *io.SectionReader, header *common.StreamHeader, index []common.IndexEntry, i int) decodedBlock {
+	section, err := indexedSection(stream, index[i])
+	if err != nil {
+		return decodedBlock{err: err}
+	}
+	raw, err := readRawBlock(section, header, common.MAX_BLOCK_SIZE)
+	if err == io.EOF {
+		err = errors.New("block index entry points at the end of the stream")
+	}
+	if err != nil {
+		return decodedBlock{err: err}
+	}
+
+	// A block that reuses a tree gets it from the last block before it that stored one
+	tree := &sharedTree{}
+	if raw.header.BlockType == common.BLOCK_REUSED_TREE {
+		if tree.dump, err = sharedTreeBefore(stream, header, index, i); err != nil {
+			return decodedBlock{err: err}
+		}
+	}
+	if err := tree.share(raw); err != nil {
+		return decodedBlock{err: err}
+	}
+	data, err := decodeBlock(raw, header)
+	if err == nil && len(data) != int(index[i].UncompressedSize) {
+		err = errors.New("block size doesn't match the block index")
+	}
+	return decodedBlock{data, err}
+}
+
+// sharedTreeBefore finds the dump of the last block before a block that stored one, only reading
+// the headers and dumps of the blocks in between. The dump is at most MAX_TREE_REUSE blocks back.
+// stream: The compressed stream
+// header: The header of the stream
+// index: The entries of the block index
+// i: The index entry of the block that reuses a tree
+func sharedTreeBefore(stream *io.SectionReader, header *common.StreamHeader, index []common.IndexEntry, i int) ([]byte, error) {
+	for j := i - 1; j >= 0 && i-j <= common.MAX_TREE_REUSE; j-- {
+		section, err := indexedSection(stream, index[j])
+		if err != nil {
+			return nil, err
+		}
+		raw, err := readBlockTree(section, header)
+		if err == io.EOF {
+			err = errors.New("block index entry points at the end of the stream")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if raw.header.BlockType == common.BLOCK_CODED {
+			return raw.treeDump, nil
+		}
+	}
+	return nil, badTree("block reuses a tree but none of the %d blocks before it stored one", common.MAX_TREE_REUSE)
+}
+
+// indexedSection returns the part of a stream that starts at the block an index entry points at
+// stream: The compressed stream
+// entry: The index entry of the block
+func indexedSection(stream *io.SectionReader, entry common.IndexEntry) (*io.SectionReader, error) {
+	offset := int64(entry.BitOffset / 8)
+	if entry.BitOffset%8 != 0 || offset >= stream.Size() {
+		return nil, errors.New("invalid block index entry")
+	}
+	return io.NewSectionReader(stream, offset, stream.Size()-offset), nil
+}
@@ -0,0 +1,139 @@
+package decompress
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"io.whypeople/huffman/common"
+)
+
+// Reader is an io.Reader that decompresses a huffman encoded stream.
+// It reads block based streams, files with a single whole-file tree and gzip files.
+type Reader struct {
+	r      *bufio.Reader
+	header common.StreamHeader
+	block  []byte      // The decoded data that hasn't been read yet
+	digest hash.Hash32 // The checksum of all the data decoded so far
+	tree   sharedTree  // The dump of the last block that stored one
+	err    error
+
+	maxBlockSize uint32 // The largest block that will be decoded
+
+	// State for files with a single whole-file tree
+	legacy    bool
+	decoder   *tableDecoder
+	bits      *common.BitReader
+	remaining int64
+
+	// State for gzip files
+	inflater   *inflater
+	gzipDigest hash.Hash32 // The CRC-32 of the current member's data so far
+	gzipSize   uint32      // The size of the current member's data so far, modulo 2^32
+}
+
+// NewReader returns a new Reader that decompresses r, the header is read immediately
+// r: The compressed stream
+func NewReader(r io.Reader) (*Reader, error) {
+	z := &Reader{r: bufio.NewReader(r), digest: common.NewChecksum(), maxBlockSize: common.MAX_BLOCK_SIZE}
+
+	// Peek at the magic number to work out what kind of file this is
+	magic, err := z.r.Peek(4)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+
+	// Files with a single whole-file tree were written in the byte order of the machine that
+	// made them, which the magic number gives away
+	switch {
+	case magic[0] == common.GZIP_ID1 && magic[1] == common.GZIP_ID2:
+		err = z.readGzipHeader()
+	case common.StreamByteOrder.Uint32(magic) == common.STREAM_MAGIC_NUMBER:
+		z.header, err = readStreamHeader(z.r)
+	case binary.LittleEndian.Uint32(magic) == common.MAGIC_NUMBER:
+		err = z.readLegacyHeader(binary.LittleEndian)
+	case binary.BigEndian.Uint32(magic) == common.MAGIC_NUMBER:
+		err = z.readLegacyHeader(binary.BigEndian)
+	default:
+		err = ErrBadMagic
+	}
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Read reads decompressed data into p
+// p: The buffer to read into
+func (z *Reader) Read(p []byte) (int, error) {
+	for len(z.block) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.block, z.err = z.nextBlock()
+	}
+
+	n := copy(p, z.block)
+	z.block = z.block[n:]
+	return n, nil
+}
+
+// nextBlock decodes the next block of data, returning io.EOF at the end of the stream
+func (z *Reader) nextBlock() ([]byte, error) {
+	if z.legacy {
+		return z.nextLegacyBlock()
+	}
+	if z.inflater != nil {
+		return z.nextGzipBlock()
+	}
+
+	block, err := readBlock(z.r, &z.header, &z.tree, z.maxBlockSize)
+	if err == io.EOF && checksummed(&z.header) {
+		if err := verifyChecksum(z.r, SECTION_DATA, z.digest.Sum32()); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	z.digest.Write(block)
+	return block, nil
+}
+
+// readLegacyHeader reads the header and tree of a file with a single whole-file tree
+// order: The byte order the file was written in
+func (z *Reader) readLegacyHeader(order binary.ByteOrder) error {
+	header, treeRoot, err := readLegacyTree(z.r, order)
+	if err != nil {
+		return err
+	}
+	z.legacy = true
+	z.remaining = header.OriginalFileSize
+
+	// Empty files have no tree
+	if z.remaining == 0 {
+		return nil
+	}
+	z.decoder, err = newTableDecoder(treeRoot)
+	z.bits = common.NewBitReader(z.r)
+	return err
+}
+
+// nextLegacyBlock decodes up to MAX_IO_BLOCK_SIZE symbols from a file with a single whole-file tree
+func (z *Reader) nextLegacyBlock() ([]byte, error) {
+	if z.remaining == 0 {
+		return nil, io.EOF
+	}
+
+	size := int64(common.MAX_IO_BLOCK_SIZE)
+	if z.remaining < size {
+		size = z.remaining
+	}
+	block := make([]byte, size)
+	if err := z.decoder.decode(z.bits, block); err != nil {
+		return nil, err
+	}
+	z.remaining -= size
+	return block, nil
+}
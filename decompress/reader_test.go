@@ -0,0 +1,100 @@
+package decompress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"io.whypeople/huffman/common"
+)
+
+// streamHeader returns the bytes of a stream header, with nothing after it
+// t: The test
+// header: The header
+func streamHeader(t *testing.T, header common.StreamHeader) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	for _, field := range header.Fields() {
+		if err := binary.Write(&out, common.StreamByteOrder, field); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out.Bytes()
+}
+
+func TestStreamHeaderVersions(t *testing.T) {
+	for version := 0; version < 256; version++ {
+		header := streamHeader(t, common.StreamHeader{
+			MagicNumber:   common.STREAM_MAGIC_NUMBER,
+			Version:       uint8(version),
+			Flags:         common.FLAG_CHECKSUM | common.FLAG_CANONICAL_CODES | common.FLAG_BLOCK_INDEX,
+			MaxCodeLength: common.MAX_CODE_SIZE,
+		})
+		_, err := NewReader(bytes.NewReader(header))
+		_, symbolErr := NewSymbolReader(bytes.NewReader(header))
+		if version >= 1 && version <= common.FORMAT_VERSION {
+			if err != nil || symbolErr != nil {
+				t.Fatalf("version %d was rejected: %v, %v", version, err, symbolErr)
+			}
+			continue
+		}
+		for _, err := range []error{err, symbolErr} {
+			if err == nil || !strings.Contains(err.Error(), "unsupported format version") {
+				t.Fatalf("version %d was read with %v", version, err)
+			}
+		}
+	}
+}
+
+func TestStreamHeaderByteOrder(t *testing.T) {
+	// Streams are little endian whatever machine wrote them
+	header := []byte{0xEF, 0xBE, 0xDE, 0xC0, common.FORMAT_VERSION, common.FLAG_CANONICAL_CODES, 12}
+	if !bytes.Equal(header, streamHeader(t, common.StreamHeader{
+		MagicNumber:   common.STREAM_MAGIC_NUMBER,
+		Version:       common.FORMAT_VERSION,
+		Flags:         common.FLAG_CANONICAL_CODES,
+		MaxCodeLength: 12,
+	})) {
+		t.Fatal("stream headers aren't written little endian")
+	}
+	if _, err := NewReader(bytes.NewReader(header)); err != nil {
+		t.Fatal(err)
+	}
+	swapped := append([]byte{header[3], header[2], header[1], header[0]}, header[4:]...)
+	if _, err := NewSymbolReader(bytes.NewReader(swapped)); !errors.Is(err, ErrBadMagic) {
+		t.Fatalf("a big endian magic number was read with %v", err)
+	}
+}
+
+func TestStreamHeaderFlags(t *testing.T) {
+	// Every flag bit is assigned, so the flags are checked for combinations no writer makes
+	if common.KNOWN_FLAGS != 0xFF {
+		t.Fatalf("flags %#x are unassigned, test that they're rejected", ^common.KNOWN_FLAGS&0xFF)
+	}
+	tests := []struct {
+		name         string
+		flags        uint8
+		alphabetSize uint32
+	}{
+		{"alphabet without canonical codes", common.FLAG_ALPHABET, 1000},
+		{"alphabet with lz77", common.FLAG_ALPHABET | common.FLAG_CANONICAL_CODES | common.FLAG_LZ77, 1000},
+		{"alphabet with adaptive", common.FLAG_ALPHABET | common.FLAG_CANONICAL_CODES | common.FLAG_ADAPTIVE, 1000},
+		{"alphabet with bwt", common.FLAG_ALPHABET | common.FLAG_CANONICAL_CODES | common.FLAG_BWT, 1000},
+		{"alphabet with context", common.FLAG_ALPHABET | common.FLAG_CANONICAL_CODES | common.FLAG_CONTEXT, 1000},
+		{"empty alphabet", common.FLAG_ALPHABET | common.FLAG_CANONICAL_CODES, 0},
+	}
+	for _, test := range tests {
+		header := streamHeader(t, common.StreamHeader{
+			MagicNumber:   common.STREAM_MAGIC_NUMBER,
+			Version:       common.FORMAT_VERSION,
+			Flags:         test.flags,
+			MaxCodeLength: common.MAX_CODE_SIZE,
+			AlphabetSize:  test.alphabetSize,
+		})
+		if _, err := NewSymbolReader(bytes.NewReader(header)); err == nil {
+			t.Fatalf("%s: the header was read", test.name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package decompress
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ReaderAt decompresses any range of a block based stream with a block index. Every block starts
+// with a fresh decoder, so the index works as a seek table and only the blocks that overlap a
+// range are decoded, so the block size the stream was written with is how finely it can be seeked.
+// Blocks that reuse a tree also read the header and tree of the block that
+// stored it. Block headers are checked as blocks are read, but the checksum of the whole data
+// can't be since only part of it is decoded. It's safe to use from several goroutines.
+type ReaderAt struct {
+	blocks  *blockIndex
+	offsets []int64 // offsets[i] is where block i starts in the decompressed data, the last is the size
+
+	mu     sync.Mutex
+	cached int    // The block held in cache, -1 if there isn't one
+	cache  []byte // The last block decoded, reads close together often share a block
+}
+
+// NewReaderAt returns a ReaderAt for a compressed stream, the header and block index are read immediately
+// r: The compressed stream
+// size: The size of the compressed stream
+func NewReaderAt(r io.ReaderAt, size int64) (*ReaderAt, error) {
+	blocks, err := readStreamIndex(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if blocks == nil {
+		return nil, errors.New("stream has no block index to seek with")
+	}
+	offsets := make([]int64, len(blocks.entries)+1)
+	for i, entry := range blocks.entries {
+		offsets[i+1] = offsets[i] + int64(entry.UncompressedSize)
+	}
+	return &ReaderAt{blocks: blocks, offsets: offsets, cached: -1}, nil
+}
+
+// Size returns the size of the decompressed data
+func (z *ReaderAt) Size() int64 {
+	return z.offsets[len(z.offsets)-1]
+}
+
+// ReadAt decompresses len(p) bytes starting at off in the decompressed data, io.EOF is returned if
+// the data ends first
+// p: The buffer to read into
+// off: The offset in the decompressed data to read from
+func (z *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	// Start from the first block that ends after off
+	entries := z.blocks.entries
+	i := sort.Search(len(entries), func(i int) bool { return z.offsets[i+1] > off })
+	n := 0
+	for ; n < len(p) && i < len(entries); i++ {
+		block, err := z.block(i)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[off+int64(n)-z.offsets[i]:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block returns the decoded data of a block, which isn't decoded again if it was the last one
+// i: The index entry of the block
+func (z *ReaderAt) block(i int) ([]byte, error) {
+	z.mu.Lock()
+	cached, cache := z.cached, z.cache
+	z.mu.Unlock()
+	if cached == i {
+		return cache, nil
+	}
+
+	decoded := decodeIndexedBlock(z.blocks.stream, &z.blocks.header, z.blocks.entries, i)
+	if decoded.err != nil {
+		return nil, decoded.err
+	}
+	z.mu.Lock()
+	z.cached, z.cache = i, decoded.data
+	z.mu.Unlock()
+	return decoded.data, nil
+}
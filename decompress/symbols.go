@@ -0,0 +1,82 @@
+package decompress
+
+import (
+	"bufio"
+	"hash"
+	"io"
+
+	"io.whypeople/huffman/common"
+)
+
+// SymbolReader decompresses a stream of symbols written by a SymbolWriter. Streams of bytes can
+// be read too, every byte is read as a symbol.
+type SymbolReader struct {
+	r      *bufio.Reader
+	header common.StreamHeader
+	block  []uint32    // The decoded symbols that haven't been read yet
+	digest hash.Hash32 // The checksum of all the data decoded so far
+	tree   sharedTree  // The dump of the last block that stored one
+	err    error
+
+	maxBlockSize uint32 // The most symbols in a block that will be decoded
+}
+
+// NewSymbolReader returns a new SymbolReader that decompresses r, the header is read immediately
+// r: The compressed stream
+func NewSymbolReader(r io.Reader) (*SymbolReader, error) {
+	s := &SymbolReader{r: bufio.NewReader(r), digest: common.NewChecksum(), maxBlockSize: common.MAX_BLOCK_SIZE}
+	header, err := readStreamHeader(s.r)
+	if err != nil {
+		return nil, err
+	}
+	if header.MagicNumber != common.STREAM_MAGIC_NUMBER {
+		return nil, ErrBadMagic
+	}
+	s.header = header
+	return s, nil
+}
+
+// AlphabetSize returns the number of symbols in the stream's alphabet
+func (s *SymbolReader) AlphabetSize() uint32 {
+	return s.header.AlphabetSize
+}
+
+// ReadSymbols reads decompressed symbols into p, returning io.EOF at the end of the stream
+// p: The buffer to read into
+func (s *SymbolReader) ReadSymbols(p []uint32) (int, error) {
+	for len(s.block) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.block, s.err = s.nextBlock()
+	}
+
+	n := copy(p, s.block)
+	s.block = s.block[n:]
+	return n, nil
+}
+
+// nextBlock decodes the next block of symbols, returning io.EOF at the end of the stream
+func (s *SymbolReader) nextBlock() ([]uint32, error) {
+	block, err := readSymbolBlock(s.r, &s.header, &s.tree, s.maxBlockSize)
+	if err == io.EOF && checksummed(&s.header) {
+		if err := verifyChecksum(s.r, SECTION_DATA, s.digest.Sum32()); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Streams of bytes are checksummed as bytes
+	if s.header.Flags&common.FLAG_ALPHABET != 0 {
+		s.digest.Write(common.SymbolData(block))
+	} else {
+		data := make([]byte, len(block))
+		for i, symbol := range block {
+			data[i] = byte(symbol)
+		}
+		s.digest.Write(data)
+	}
+	return block, nil
+}
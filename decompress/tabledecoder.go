@@ -0,0 +1,249 @@
+package decompress
+
+import (
+	"errors"
+
+	"io.whypeople/huffman/common"
+)
+
+const TABLE_BITS = 11       // The number of bits used to index the primary decoding table.
+const SUB_TABLE_BITS = 6    // The most bits used to index a secondary table, longer codes go on to another table.
+const MAX_TABLE_SYMBOLS = 4 // The most symbols a single primary table entry can decode.
+
+// An entry in a decoding table, it decodes up to MAX_TABLE_SYMBOLS symbols at once
+type tableEntry struct {
+	symbols [MAX_TABLE_SYMBOLS]uint32
+	ends    [MAX_TABLE_SYMBOLS]uint8 // The number of bits used once each symbol has been decoded
+	count   uint8                    // The number of symbols in the entry, 0 for an invalid code
+	sub     *subTable                // The table for codes longer than the table's index
+}
+
+// A secondary table for codes that share a prefix and are too long for the table above it.
+// Tables are nested so each one stays small however long the codes are.
+type subTable struct {
+	shift   uint // The length of the prefix, the table is indexed by the bits after it
+	bits    uint // The number of bits after the prefix used to index the table
+	entries []tableEntry
+}
+
+// tableDecoder decodes huffman codes by looking up the next few bits in tables instead of
+// walking the tree one bit at a time
+type tableDecoder struct {
+	single  bool   // The tree is a single symbol with a zero length code
+	symbol  uint32 // The symbol of a single symbol tree
+	bits    uint   // The number of bits used to index the primary table
+	primary []tableEntry
+}
+
+// A code taken from a huffman tree, with the first bit of the code in the lowest bit
+type tableCode struct {
+	symbol uint32
+	code   uint64
+	length uint8
+}
+
+// newTableDecoder builds the decoding tables for a huffman tree
+// treeRoot: The root of the huffman tree
+func newTableDecoder(treeRoot common.HuffNode) (*tableDecoder, error) {
+	// A tree with a single symbol uses zero length codes
+	if treeRoot.IsLeaf() {
+		return &tableDecoder{single: true, symbol: treeRoot.Data().Symbol}, nil
+	}
+
+	codes := make([]tableCode, 0, common.ALPHABET_SIZE)
+	if err := collectCodes(treeRoot, 0, 0, &codes); err != nil {
+		return nil, err
+	}
+
+	// The primary table only needs to be as big as the longest code
+	d := &tableDecoder{bits: TABLE_BITS}
+	maxLength := uint(0)
+	for _, c := range codes {
+		if uint(c.length) > maxLength {
+			maxLength = uint(c.length)
+		}
+	}
+	if maxLength < d.bits {
+		d.bits = maxLength
+	}
+	d.primary = make([]tableEntry, 1<<d.bits)
+
+	fillTable(d.primary, codes, 0, d.bits)
+	d.combineEntries()
+	return d, nil
+}
+
+// collectCodes walks a huffman tree and collects the code of every symbol
+// n: The current node
+// code: The bits of the path to n, first bit lowest
+// length: The length of the path to n
+// codes: The list of codes to add to
+func collectCodes(n common.HuffNode, code uint64, length uint8, codes *[]tableCode) error {
+	if n == nil {
+		return badTree("internal node with a missing child")
+	}
+	if n.IsLeaf() {
+		*codes = append(*codes, tableCode{n.Data().Symbol, code, length})
+		return nil
+	}
+	if length == common.MAX_CODE_SIZE {
+		return badTree("codes longer than %d bits", common.MAX_CODE_SIZE)
+	}
+	if err := collectCodes(n.Left(), code, length+1, codes); err != nil {
+		return err
+	}
+	return collectCodes(n.Right(), code|1<<length, length+1, codes)
+}
+
+// fillTable fills a table with the codes that fit in its index, and groups longer codes by their
+// next bits into secondary tables
+// entries: The table to fill
+// codes: The codes that share the table's prefix
+// shift: The length of the prefix
+// bits: The number of bits after the prefix used to index the table
+func fillTable(entries []tableEntry, codes []tableCode, shift uint, bits uint) {
+	longCodes := make(map[uint64][]tableCode)
+	for _, c := range codes {
+		if uint(c.length) <= shift+bits {
+			fillEntries(entries, c.code>>shift, uint(c.length)-shift, c.symbol, c.length)
+		} else {
+			index := c.code >> shift & (1<<bits - 1)
+			longCodes[index] = append(longCodes[index], c)
+		}
+	}
+	for index, group := range longCodes {
+		entries[index].sub = newSubTable(group, shift+bits)
+	}
+}
+
+// newSubTable builds the secondary table for long codes that share a prefix. The table is only
+// as big as the longest code needs, up to SUB_TABLE_BITS, so a few very long codes can't make
+// the tables huge.
+// codes: The codes that share the prefix
+// prefixBits: The length of the prefix
+func newSubTable(codes []tableCode, prefixBits uint) *subTable {
+	sub := &subTable{shift: prefixBits}
+	for _, c := range codes {
+		if uint(c.length)-prefixBits > sub.bits {
+			sub.bits = uint(c.length) - prefixBits
+		}
+	}
+	if sub.bits > SUB_TABLE_BITS {
+		sub.bits = SUB_TABLE_BITS
+	}
+
+	sub.entries = make([]tableEntry, 1<<sub.bits)
+	fillTable(sub.entries, codes, prefixBits, sub.bits)
+	return sub
+}
+
+// fillEntries points every table entry whose low bits match a code at the code's symbol
+// entries: The table to fill
+// code: The bits of the code that index the table
+// indexBits: The number of bits of the code that index the table
+// symbol: The symbol of the code
+// length: The full length of the code
+func fillEntries(entries []tableEntry, code uint64, indexBits uint, symbol uint32, length uint8) {
+	for i := code; i < uint64(len(entries)); i += 1 << indexBits {
+		entries[i].symbols[0] = symbol
+		entries[i].ends[0] = length
+		entries[i].count = 1
+	}
+}
+
+// combineEntries packs extra symbols into primary entries when the bits left over after the
+// first code hold more complete codes
+func (d *tableDecoder) combineEntries() {
+	single := make([]tableEntry, len(d.primary))
+	copy(single, d.primary)
+
+	for i := range d.primary {
+		entry := &d.primary[i]
+		for entry.count > 0 && entry.count < MAX_TABLE_SYMBOLS && entry.sub == nil {
+			used := uint(entry.ends[entry.count-1])
+
+			// The remaining bits are zero padded, but a code that fits in them is still complete
+			next := single[uint(i)>>used]
+			if next.count == 0 || next.sub != nil || used+uint(next.ends[0]) > d.bits {
+				break
+			}
+			entry.symbols[entry.count] = next.symbols[0]
+			entry.ends[entry.count] = uint8(used) + next.ends[0]
+			entry.count++
+		}
+	}
+}
+
+// decode decodes len(out) symbols
+// br: The bits to decode
+// out: The buffer to write the decoded symbols to
+func (d *tableDecoder) decode(br *common.BitReader, out []byte) error {
+	if d.single {
+		for i := range out {
+			out[i] = byte(d.symbol)
+		}
+		return nil
+	}
+
+	for i := 0; i < len(out); {
+		entry, err := d.lookup(br)
+		if err != nil {
+			return err
+		}
+
+		// Don't decode past the end of the output
+		count := int(entry.count)
+		if count > len(out)-i {
+			count = len(out) - i
+		}
+		used := uint(entry.ends[count-1])
+		if used > br.Buffered() {
+			return exhausted(br)
+		}
+
+		for j := 0; j < count; j++ {
+			out[i+j] = byte(entry.symbols[j])
+		}
+		i += count
+		br.Consume(used)
+	}
+	return nil
+}
+
+// decodeSymbol decodes a single symbol
+// br: The bits to decode
+func (d *tableDecoder) decodeSymbol(br *common.BitReader) (uint32, error) {
+	if d.single {
+		return d.symbol, nil
+	}
+
+	entry, err := d.lookup(br)
+	if err != nil {
+		return 0, err
+	}
+	used := uint(entry.ends[0])
+	if used > br.Buffered() {
+		return 0, exhausted(br)
+	}
+	br.Consume(used)
+	return entry.symbols[0], nil
+}
+
+// lookup finds the table entry for the next bits, refilling the bit reader if it doesn't hold a
+// whole code
+// br: The bits to decode
+func (d *tableDecoder) lookup(br *common.BitReader) (*tableEntry, error) {
+	bits := br.Peek(common.MAX_CODE_SIZE)
+	entry := &d.primary[bits&(uint64(1)<<d.bits-1)]
+	for entry.sub != nil {
+		sub := entry.sub
+		entry = &sub.entries[bits>>sub.shift&(uint64(1)<<sub.bits-1)]
+	}
+	if entry.count == 0 {
+		if br.Buffered() == 0 {
+			return nil, exhausted(br)
+		}
+		return nil, errors.New("invalid huffman code in payload")
+	}
+	return entry, nil
+}
@@ -0,0 +1,209 @@
+package decompress
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"io.whypeople/huffman/common"
+)
+
+// huffmanLengths returns the code length of every byte of a huffman code for data, merging the
+// two lightest groups of symbols until one is left
+// data: The data the code is for
+func huffmanLengths(data []byte) []uint8 {
+	type group struct {
+		weight  int
+		symbols []int
+	}
+	var counts [common.ALPHABET_SIZE]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var groups []group
+	for symbol, count := range counts {
+		if count != 0 {
+			groups = append(groups, group{count, []int{symbol}})
+		}
+	}
+
+	lengths := make([]uint8, common.ALPHABET_SIZE)
+	for len(groups) > 1 {
+		sort.SliceStable(groups, func(i, j int) bool { return groups[i].weight < groups[j].weight })
+		merged := group{groups[0].weight + groups[1].weight, append(groups[0].symbols, groups[1].symbols...)}
+		for _, symbol := range merged.symbols {
+			lengths[symbol]++
+		}
+		groups = append([]group{merged}, groups[2:]...)
+	}
+	return lengths
+}
+
+// encodeWithTree codes data with the codes of a huffman tree
+// t: The test
+// treeRoot: The root of the huffman tree
+// data: The data to code
+func encodeWithTree(t testing.TB, treeRoot common.HuffNode, data []byte) []byte {
+	var codes []tableCode
+	if err := collectCodes(treeRoot, 0, 0, &codes); err != nil {
+		t.Fatal(err)
+	}
+	bySymbol := make(map[byte]tableCode)
+	for _, c := range codes {
+		bySymbol[byte(c.symbol)] = c
+	}
+
+	out := new(bytes.Buffer)
+	bw := common.NewBitWriter(out)
+	for _, b := range data {
+		bw.WriteBits(bySymbol[b].code, uint(bySymbol[b].length))
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// walkTree decodes len(out) symbols by walking the tree one bit at a time, the way blocks were
+// decoded before the tables
+// treeRoot: The root of the huffman tree
+// br: The bits to decode
+// out: The buffer to write the decoded symbols to
+func walkTree(treeRoot common.HuffNode, br *common.BitReader, out []byte) error {
+	for i := range out {
+		n := treeRoot
+		for !n.IsLeaf() {
+			bit, err := br.ReadBits(1)
+			if err != nil {
+				return err
+			}
+			if bit == 1 {
+				n = n.Right()
+			} else {
+				n = n.Left()
+			}
+		}
+		out[i] = byte(n.Data().Symbol)
+	}
+	return nil
+}
+
+// tableEntries returns the number of entries in a table and every table below it
+// entries: The table
+func tableEntries(entries []tableEntry) int {
+	total := len(entries)
+	for _, entry := range entries {
+		if entry.sub != nil {
+			total += tableEntries(entry.sub.entries)
+		}
+	}
+	return total
+}
+
+// skewedData returns n bytes from a fixed seed where low bytes are much more common
+// n: The number of bytes
+func skewedData(n int) []byte {
+	r := rand.New(rand.NewSource(1))
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(r.ExpFloat64() * 12)
+	}
+	return data
+}
+
+func TestTableDecoderMatchesTreeWalk(t *testing.T) {
+	data := skewedData(1 << 18)
+	treeRoot, err := buildCanonicalTree(huffmanLengths(data), common.MAX_CODE_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := encodeWithTree(t, treeRoot, data)
+
+	d, err := newTableDecoder(treeRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromTable := make([]byte, len(data))
+	if err := d.decode(common.NewBitReader(bytes.NewReader(payload)), fromTable); err != nil {
+		t.Fatal(err)
+	}
+	fromTree := make([]byte, len(data))
+	if err := walkTree(treeRoot, common.NewBitReader(bytes.NewReader(payload)), fromTree); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fromTable, data) || !bytes.Equal(fromTree, data) {
+		t.Fatal("decoded data doesn't match")
+	}
+}
+
+func TestTableDecoderLongCodes(t *testing.T) {
+	// Codes of every length from 1 to 31 bits and two of 32 bits make a complete code
+	lengths := make([]uint8, 33)
+	for symbol := range lengths {
+		lengths[symbol] = uint8(symbol + 1)
+	}
+	lengths[32] = 32
+	treeRoot, err := buildCanonicalTree(lengths, common.MAX_CODE_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := newTableDecoder(treeRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := tableEntries(d.primary); entries > 2<<TABLE_BITS {
+		t.Fatalf("tables for 33 codes have %d entries", entries)
+	}
+
+	var data []byte
+	for round := 0; round < 4; round++ {
+		for symbol := range lengths {
+			data = append(data, byte(symbol))
+		}
+	}
+	rand.New(rand.NewSource(1)).Shuffle(len(data), func(i, j int) { data[i], data[j] = data[j], data[i] })
+	out := make([]byte, len(data))
+	if err := d.decode(common.NewBitReader(bytes.NewReader(encodeWithTree(t, treeRoot, data))), out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("decoded data doesn't match")
+	}
+}
+
+// benchmarkDecode codes a megabyte of skewed data and times decoding it
+// b: The benchmark
+// decode: Decodes len(out) symbols of the payload with the tree
+func benchmarkDecode(b *testing.B, decode func(treeRoot common.HuffNode, br *common.BitReader, out []byte) error) {
+	data := skewedData(1 << 20)
+	treeRoot, err := buildCanonicalTree(huffmanLengths(data), common.MAX_CODE_SIZE)
+	if err != nil {
+		b.Fatal(err)
+	}
+	payload := encodeWithTree(b, treeRoot, data)
+	out := make([]byte, len(data))
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := decode(treeRoot, common.NewBitReader(bytes.NewReader(payload)), out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeTable(b *testing.B) {
+	benchmarkDecode(b, func(treeRoot common.HuffNode, br *common.BitReader, out []byte) error {
+		d, err := newTableDecoder(treeRoot)
+		if err != nil {
+			return err
+		}
+		return d.decode(br, out)
+	})
+}
+
+func BenchmarkDecodeTreeWalk(b *testing.B) {
+	benchmarkDecode(b, walkTree)
+}
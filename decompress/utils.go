@@ -1,28 +1,215 @@
 package decompress
 
 import (
+	"bytes"
+	"encoding/binary"
+
 	"io.whypeople/huffman/common"
 )
 
 // BuildHuffmanTreeFromDump builds a huffman tree from a compressed file tree dump
 // treeDump: The tree dump to build the tree from
-func BuildHuffmanTreeFromDump(treeDump []byte) common.HuffNode {
+func BuildHuffmanTreeFromDump(treeDump []byte) (common.HuffNode, error) {
 	stack := make([]common.HuffNode, 0)
 
 	for i := 0; i < len(treeDump); i++ {
-		if treeDump[i] == common.LEAF_DUMP_CHAR {
-			stack = append(stack, common.NewNode(treeDump[i + 1], 0))
+		switch treeDump[i] {
+		case common.LEAF_DUMP_CHAR:
+			if i + 1 == len(treeDump) {
+				return nil, badTree("tree dump ends part way through a leaf")
+			}
+			stack = append(stack, common.NewNode(uint32(treeDump[i + 1]), 0))
 			i++
-		} else {
+		case common.INTERNAL_DUMP_CHAR:
 			// Pop from the stack and join nodes
+			if len(stack) < 2 {
+				return nil, badTree("tree dump joins a node that doesn't exist")
+			}
 			right := stack[len(stack) - 1]
 			stack = stack[:len(stack) - 1]
 			left := stack[len(stack) - 1]
 			stack = stack[:len(stack) - 1]
 			stack = append(stack, left.Join(right))
+		default:
+			return nil, badTree("tree dump has unknown node type %#x", treeDump[i])
 		}
 	}
 
 	// Root node
-	return stack[len(stack) - 1]
-}
\ No newline at end of file
+	if len(stack) != 1 {
+		return nil, badTree("tree dump leaves %d nodes unjoined", len(stack))
+	}
+	return stack[0], nil
+}
+
+// BuildHuffmanTreeFromCodeLengths builds the huffman tree of a canonical code from a code length dump
+// lengthDump: The code length dump to build the tree from
+// maxCodeLength: The longest code allowed by the stream header, 0 if there's no limit
+func BuildHuffmanTreeFromCodeLengths(lengthDump []byte, maxCodeLength int) (common.HuffNode, error) {
+	lengths, _, err := readCodeLengthDump(lengthDump, common.ALPHABET_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	return buildCanonicalTree(lengths, maxCodeLength)
+}
+
+// buildCanonicalTree builds the huffman tree of a canonical code from its code lengths
+// lengths: The code length of every symbol in the alphabet
+// maxCodeLength: The longest code allowed by the stream header, 0 if there's no limit
+func buildCanonicalTree(lengths []uint8, maxCodeLength int) (common.HuffNode, error) {
+	return buildSymbolTree(common.SparseCodeLengths(lengths), maxCodeLength)
+}
+
+// buildSymbolTree builds the huffman tree of a canonical code from the code lengths of the symbols
+// that have a code
+// lengths: The symbols that have a code and their code lengths, in symbol order
+// maxCodeLength: The longest code allowed by the stream header, 0 if there's no limit
+func buildSymbolTree(lengths []common.SymbolLength, maxCodeLength int) (common.HuffNode, error) {
+	treeRoot := common.NewNode(common.NODE_JOIN_SYMBOL, 0)
+	codes := common.CanonicalSymbolCodes(lengths)
+	for i, l := range lengths {
+		symbol, length := l.Symbol, l.Length
+		if length == 0 {
+			continue
+		}
+		if maxCodeLength != 0 && int(length) > maxCodeLength {
+			return nil, badTree("code length %d is longer than the stream's limit of %d", length, maxCodeLength)
+		}
+
+		// Walk the code from its most significant bit, creating internal nodes on the way
+		navNode := treeRoot
+		for bit := int(length) - 1; bit > 0; bit-- {
+			navNode = childNode(navNode, codes[i]>>uint(bit)&1 == 1)
+			if navNode == nil {
+				return nil, badTree("code lengths don't make a prefix code")
+			}
+		}
+
+		// Canonical codes from valid lengths never reuse a path
+		right := codes[i]&1 == 1
+		if (right && navNode.Right() != nil) || (!right && navNode.Left() != nil) {
+			return nil, badTree("code lengths don't make a prefix code")
+		}
+		leaf := common.NewNode(symbol, 0)
+		if right {
+			navNode.SetRight(leaf)
+		} else {
+			navNode.SetLeft(leaf)
+		}
+	}
+
+	if treeRoot.IsLeaf() {
+		return nil, badTree("code length dump has no symbols")
+	}
+
+	// A lone symbol's code carries no information, so it's never written
+	if treeRoot.Right() == nil && treeRoot.Left().IsLeaf() {
+		return treeRoot.Left(), nil
+	}
+	return treeRoot, nil
+}
+
+// childNode returns the internal node below n in the given direction, creating it if it's missing.
+// Returns nil if a symbol is already in the way. Internal nodes always have a child by the time
+// another code is walked, so any node without children is a symbol.
+// n: The internal node
+// right: Whether to take the right child
+func childNode(n common.HuffNode, right bool) common.HuffNode {
+	child := n.Left()
+	if right {
+		child = n.Right()
+	}
+
+	if child == nil {
+		child = common.NewNode(common.NODE_JOIN_SYMBOL, 0)
+		if right {
+			n.SetRight(child)
+		} else {
+			n.SetLeft(child)
+		}
+		return child
+	}
+	if child.IsLeaf() {
+		return nil
+	}
+	return child
+}
+
+// readCodeLengthDump unpacks the code length of every symbol from a code length dump, returning
+// the lengths and the number of bytes of the dump they took up
+// lengthDump: The code length dump
+// alphabetSize: The number of symbols in the alphabet
+func readCodeLengthDump(lengthDump []byte, alphabetSize int) ([]uint8, int, error) {
+	if len(lengthDump) == 0 || lengthDump[0] == 0 || lengthDump[0] > 8 {
+		return nil, 0, badTree("malformed code length dump")
+	}
+	width := uint(lengthDump[0])
+	br := common.NewBitReader(bytes.NewReader(lengthDump[1:]))
+	pos := uint(0)
+
+	// Reads n bits, least significant first
+	read := func(n uint) (uint8, bool) {
+		value, err := br.ReadBits(n)
+		pos += n
+		return uint8(value), err == nil
+	}
+
+	lengths := make([]uint8, 0, alphabetSize)
+	for len(lengths) < alphabetSize {
+		length, ok := read(width)
+		if !ok {
+			return nil, 0, badTree("code length dump ends early")
+		}
+		if length != 0 {
+			lengths = append(lengths, length)
+			continue
+		}
+
+		// Runs of zero lengths are followed by their length
+		run, ok := read(8)
+		if !ok || len(lengths)+int(run)+1 > alphabetSize {
+			return nil, 0, badTree("malformed code length dump")
+		}
+		for i := 0; i <= int(run); i++ {
+			lengths = append(lengths, 0)
+		}
+	}
+	return lengths, 1 + int(pos+7)/8, nil
+}
+
+// readSymbolLengthDump unpacks the symbols that have a code and their code lengths from a dump made
+// by CreateSymbolLengthDump
+// lengthDump: The symbol length dump
+// alphabetSize: The number of symbols in the alphabet
+func readSymbolLengthDump(lengthDump []byte, alphabetSize uint32) ([]common.SymbolLength, error) {
+	r := bytes.NewReader(lengthDump)
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count == 0 || count > uint64(alphabetSize) || count > uint64(len(lengthDump)) {
+		return nil, badTree("malformed symbol length dump")
+	}
+
+	lengths := make([]common.SymbolLength, count)
+	next := uint64(0)
+	for i := range lengths {
+		gap, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, badTree("symbol length dump ends early")
+		}
+		length, err := r.ReadByte()
+		if err != nil {
+			return nil, badTree("symbol length dump ends early")
+		}
+
+		// Symbols are stored in order as gaps, so each one must land further into the alphabet
+		symbol := next + gap
+		if gap >= uint64(alphabetSize) || symbol >= uint64(alphabetSize) || length == 0 {
+			return nil, badTree("malformed symbol length dump")
+		}
+		lengths[i] = common.SymbolLength{Symbol: uint32(symbol), Length: length}
+		next = symbol + 1
+	}
+	if r.Len() != 0 {
+		return nil, badTree("malformed symbol length dump")
+	}
+	return lengths, nil
+}
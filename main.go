@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"time"
 
 	"io.whypeople/huffman/common"
 	"io.whypeople/huffman/compress"
@@ -11,15 +16,249 @@ import (
 	"github.com/akamensky/argparse"
 )
 
-const OUT_FLAGS = os.O_CREATE | os.O_WRONLY
+const OUT_FLAGS = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
 
-func logStats(infile *os.File, outfile *os.File) {
-	inSize := common.GetFileSize(infile)
-	outSize := common.GetFileSize(outfile)
-	fmt.Println("Uncompressed File Size:", inSize)
-	fmt.Println("Compressed file size:", outSize)
-	fmt.Printf("Compression Ratio: %3.2v\n", float64(inSize) / float64(outSize))
-	fmt.Printf("Space Saving: %3.4v%%\n", (float64(inSize - outSize) / float64(inSize)) * 100.0)
+// The file path that stands for stdin or stdout
+const STDIO_PATH = "-"
+
+// logStats prints the sizes of the input and output to stderr, so they stay out of piped output
+// inSize: The number of bytes read
+// outSize: The number of bytes written
+func logStats(inSize int64, outSize int64) {
+	fmt.Fprintln(os.Stderr, "Uncompressed File Size:", inSize)
+	fmt.Fprintln(os.Stderr, "Compressed file size:", outSize)
+
+	// Empty files have no meaningful ratio
+	if inSize == 0 || outSize == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Compression Ratio: %3.2v\n", float64(inSize) / float64(outSize))
+	fmt.Fprintf(os.Stderr, "Space Saving: %3.4v%%\n", (float64(inSize - outSize) / float64(inSize)) * 100.0)
+}
+
+// exitWithError prints an error to stderr and exits with a failure status
+// err: The error to print
+func exitWithError(err error) {
+	fmt.Fprintln(os.Stderr, "huffman:", err)
+	os.Exit(1)
+}
+
+// openInput opens the file to read from, or stdin
+// path: The path of the file, or STDIO_PATH for stdin
+func openInput(path string) (*os.File, error) {
+	if path == STDIO_PATH {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
+
+// openOutput opens the file to write to, or stdout
+// path: The path of the file, or STDIO_PATH for stdout
+func openOutput(path string) (*os.File, error) {
+	if path == STDIO_PATH {
+		return os.Stdout, nil
+	}
+	return os.OpenFile(path, OUT_FLAGS, 0600)
+}
+
+// fileSizes returns the sizes of the input and output files
+// infile: The file that was read
+// outfile: The file that was written
+func fileSizes(infile *os.File, outfile *os.File) (int64, int64, error) {
+	inSize, err := common.GetFileSize(infile)
+	if err != nil {
+		return 0, 0, err
+	}
+	outSize, err := common.GetFileSize(outfile)
+	return inSize, outSize, err
+}
+
+// isRegular returns whether a file is a regular file, which can be sized and read at any offset
+// file: The file to check
+func isRegular(file *os.File) bool {
+	fi, err := file.Stat()
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// streamFile compresses or decompresses a stream front to back, for pipes that can't be seeked or sized.
+// Returns the number of bytes read and written
+// ctx: The context that stops the stream
+// infile: The stream to read from
+// outfile: The stream to write to
+// encoder: The encoder to compress with, nil to decompress instead
+// decoder: The decoder to decompress with
+func streamFile(ctx context.Context, infile io.Reader, outfile io.Writer, encoder *compress.Encoder, decoder *decompress.Decoder) (int64, int64, error) {
+	in := &common.CountingReader{R: infile}
+	out := &common.CountingWriter{W: outfile}
+
+	if encoder != nil {
+		err := encoder.EncodeContext(ctx, out, in)
+		return in.N, out.N, err
+	}
+	err := decoder.DecodeContext(ctx, out, in)
+	return in.N, out.N, err
+}
+
+// createArchive packs a file or directory tree into an archive.
+// Returns the number of bytes of file data archived and the size of the archive
+// root: The path of the file or directory to archive
+// outPath: The path of the archive, or STDIO_PATH for stdout
+// newWriter: Creates the writer that compresses each file
+func createArchive(root string, outPath string, newWriter func(io.Writer) (io.WriteCloser, error)) (int64, int64, error) {
+	outfile, err := openOutput(outPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer outfile.Close()
+
+	archive := compress.NewArchiveWriter(outfile, newWriter)
+	inSize, err := archive.AddPath(root)
+	if err != nil {
+		return inSize, archive.Written(), err
+	}
+	err = archive.Close()
+	return inSize, archive.Written(), err
+}
+
+// openArchive opens an archive and reads its central directory, archives have to be files so the
+// directory at the end can be found
+// path: The path of the archive
+func openArchive(path string) (*os.File, *decompress.ArchiveReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isRegular(file) {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	size, err := common.GetFileSize(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	archive, err := decompress.NewArchiveReader(file, size)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, archive, nil
+}
+
+// extractArchive extracts every entry of an archive into a directory.
+// Returns the size of the archive and the number of bytes of file data extracted
+// path: The path of the archive
+// dir: The directory to extract into, STDIO_PATH for the working directory
+func extractArchive(path string, dir string) (int64, int64, error) {
+	file, archive, err := openArchive(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	if dir == STDIO_PATH {
+		dir = "."
+	}
+	outSize := int64(0)
+	for _, entry := range archive.Entries() {
+		outSize += int64(entry.Size)
+	}
+	inSize, err := common.GetFileSize(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return inSize, outSize, archive.Extract(dir)
+}
+
+// listArchive prints the entries of an archive to stdout, only the central directory is read
+// path: The path of the archive
+func listArchive(path string) error {
+	file, archive, err := openArchive(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, entry := range archive.Entries() {
+		mode := os.FileMode(entry.Mode).Perm()
+		name := entry.Path
+		switch entry.Type {
+		case common.ENTRY_DIRECTORY:
+			mode |= os.ModeDir
+			name += "/"
+		case common.ENTRY_SYMLINK:
+			mode |= os.ModeSymlink
+			name += " -> " + entry.LinkTarget
+		}
+		modTime := time.Unix(0, entry.ModTime).Format("2006-01-02 15:04")
+		fmt.Printf("%s %12d %s %s\n", mode, entry.Size, modTime, name)
+	}
+	return nil
+}
+
+// The names of the stream header flags, in bit order
+var flagNames = []string{"checksum", "canonical codes", "block index", "adaptive", "lz77", "alphabet", "bwt", "context"}
+
+// listFile prints the entries of an archive, or the headers and code tables of a compressed file
+// path: The path of the file, or STDIO_PATH for stdin
+func listFile(path string) error {
+	infile, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer infile.Close()
+	if isRegular(infile) && decompress.IsArchive(infile) {
+		return listArchive(path)
+	}
+
+	info, err := decompress.Inspect(infile)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Format:", info.Format)
+	fmt.Printf("Magic Number: 0x%X\n", info.MagicNumber)
+	if info.Format == decompress.FORMAT_STREAM {
+		names := make([]string, 0)
+		for bit, name := range flagNames {
+			if info.Flags&(1<<bit) != 0 {
+				names = append(names, name)
+			}
+		}
+		fmt.Println("Version:", info.Version)
+		fmt.Printf("Flags: %#02x (%s)\n", info.Flags, strings.Join(names, ", "))
+		fmt.Println("Max Code Length:", info.MaxCodeLength)
+		fmt.Println("Alphabet Size:", info.AlphabetSize)
+		fmt.Println("Blocks:", info.Blocks)
+		fmt.Println("Reused Trees:", info.ReusedTrees)
+		fmt.Println("Stored Blocks:", info.StoredBlocks)
+	}
+	fmt.Println("Tree Size:", info.TreeSize)
+	fmt.Println("Original File Size:", info.OriginalSize)
+	fmt.Println("Compressed File Size:", info.CompressedSize)
+	fmt.Printf("Compression Ratio: %.2f\n", info.Ratio())
+	if info.Format == decompress.FORMAT_GZIP {
+		return nil
+	}
+	fmt.Println("Symbols:", info.Symbols)
+	fmt.Println("Code Lengths:")
+	for length, count := range info.CodeLengths {
+		if count != 0 {
+			fmt.Printf("%6d bits: %d\n", length, count)
+		}
+	}
+	return nil
+}
+
+// testFile decodes a compressed file or archive without writing anything, to check it isn't corrupt
+// path: The path of the file, or STDIO_PATH for stdin
+// maxGoroutines: The maximum number of goroutines to use
+func testFile(path string, maxGoroutines int) error {
+	infile, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer infile.Close()
+	return decompress.VerifyFile(infile, maxGoroutines)
 }
 
 func main() {
@@ -28,59 +267,203 @@ func main() {
 
 
 	// File args
-	infileOpts := &argparse.Options{Required: true, Help: "Required Input File"}
-	infile := argparser.File("i", "infile", os.O_RDWR, 0600, infileOpts)
-	outfileOpts := &argparse.Options{Required: true, Help: "Required Output File Path"}
-	outfile := argparser.File("o", "outfile", OUT_FLAGS, 0600, outfileOpts)
-	defer outfile.Close()
-	defer infile.Close()
+	infileOpts := &argparse.Options{Required: false, Help: "Input File, - or omitted for stdin", Default: STDIO_PATH}
+	infilePath := argparser.String("i", "infile", infileOpts)
+	outfileOpts := &argparse.Options{Required: false, Help: "Output File Path, - or omitted for stdout", Default: STDIO_PATH}
+	outfilePath := argparser.String("o", "outfile", outfileOpts)
 	
 	// Mode
 	decodeOpts := &argparse.Options{Required: false, Help: "Decode Mode"}
 	decode := argparser.Flag("d", "decode", decodeOpts)
 	encodeOPts := &argparse.Options{Required: false, Help: "Encode Mode"}
 	encode := argparser.Flag("e", "encode", encodeOPts)
+	adaptiveOpts := &argparse.Options{Required: false, Help: "Use adaptive Huffman coding when encoding"}
+	adaptive := argparser.Flag("a", "adaptive", adaptiveOpts)
+	lz77Opts := &argparse.Options{Required: false, Help: "Replace repeated data with LZ77 matches when encoding"}
+	lz77 := argparser.Flag("z", "lz77", lz77Opts)
+	windowOpts := &argparse.Options{Required: false, Help: "LZ77 window size, a power of two", Default: common.MAX_LZ77_WINDOW}
+	window := argparser.Int("w", "window", windowOpts)
+	gzipOpts := &argparse.Options{Required: false, Help: "Write a gzip file when encoding"}
+	gzip := argparser.Flag("", "gzip", gzipOpts)
+	bwtOpts := &argparse.Options{Required: false, Help: "Burrows-Wheeler transform large blocks when encoding, best for text"}
+	bwt := argparser.Flag("b", "bwt", bwtOpts)
+	contextOpts := &argparse.Options{Required: false, Help: "Pick each byte's tree by the byte before it when encoding"}
+	contextModel := argparser.Flag("c", "context", contextOpts)
+
+	// Archives
+	archiveOpts := &argparse.Options{Required: false, Help: "Pack the -i file or directory tree into an archive when encoding, extract an archive into the -o directory when decoding"}
+	archive := argparser.Flag("", "archive", archiveOpts)
+	listOpts := &argparse.Options{Required: false, Help: "List the entries of an archive, or the headers and code lengths of a compressed file"}
+	listPath := argparser.String("l", "list", listOpts)
+
+	// Checking
+	testOpts := &argparse.Options{Required: false, Help: "Decode a compressed file or archive to check it isn't corrupt, nothing is written"}
+	testPath := argparser.String("t", "test", testOpts)
 
 	// Concurrency
 	goroutineOpts := &argparse.Options{Required: false, Help: "Maximum Number of Goroutines to use", Default: 4}
 	goroutines := argparser.Int("g", "goroutines", goroutineOpts)
+
+	// Limits
+	blockSizeOpts := &argparse.Options{Required: false, Help: "Bytes in each block when encoding, 0 for the default of the encoding. Smaller blocks let ranges of the data be decoded with less work", Default: 0}
+	blockSize := argparser.Int("", "block-size", blockSizeOpts)
+	memoryOpts := &argparse.Options{Required: false, Help: "Most bytes of memory to decode or encode blocks with, 0 for no limit", Default: 0}
+	memoryLimit := argparser.Int("", "memory-limit", memoryOpts)
 	
 	// Parse args
 	err := argparser.Parse(os.Args)
 	if err != nil {
-		fmt.Println(argparser.Usage(err))
+		fmt.Fprintln(os.Stderr, argparser.Usage(err))
+		return
+	}
+
+	// Listing only reads headers, code tables and an archive's central directory
+	if *listPath != "" {
+		if err := listFile(*listPath); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	// Testing exits with an error status on corruption, so scripts can check files
+	if *testPath != "" {
+		if err := testFile(*testPath, *goroutines); err != nil {
+			fmt.Fprintln(os.Stderr, *testPath + ":", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, *testPath + ": OK")
 		return
 	}
 
 	// Handle mode args
 	if *decode && *encode {
-		fmt.Println(argparser.Usage("Must specify at most 1 mode flag (-e or -d)"))
+		fmt.Fprintln(os.Stderr, argparser.Usage("Must specify at most 1 mode flag (-e or -d)"))
 		return
 	}
 
 	if !*decode && !*encode {
-		fmt.Println(argparser.Usage("Must specify at least 1 mode flag (-e or -d)"))
+		fmt.Fprintln(os.Stderr, argparser.Usage("Must specify at least 1 mode flag (-e or -d)"))
+		return
+	}
+
+	// Pick how to encode
+	encodings := 0
+	for _, set := range []bool{*adaptive, *lz77, *gzip, *bwt, *contextModel} {
+		if set {
+			encodings++
+		}
+	}
+	backend := compress.BACKEND_HUFFMAN
+	switch {
+	case encodings > 1:
+		fmt.Fprintln(os.Stderr, argparser.Usage("Must specify at most 1 encoding flag (-a, -z, -b, -c or --gzip)"))
 		return
+	case *adaptive:
+		backend = compress.BACKEND_ADAPTIVE
+	case *lz77:
+		backend = compress.BACKEND_LZ77
+	case *gzip:
+		backend = compress.BACKEND_GZIP
+	case *bwt:
+		backend = compress.BACKEND_BWT
+	case *contextModel:
+		backend = compress.BACKEND_CONTEXT
 	}
 
 	// Handle concurrency args
 	if *goroutines < 1 {
-		fmt.Println(argparser.Usage("Must specify at least 1 goroutine"))
+		fmt.Fprintln(os.Stderr, argparser.Usage("Must specify at least 1 goroutine"))
 		return
 	}
 
-	// compress/decompress
+	// Set up the encoder or decoder
+	encoderOpts := []compress.Option{compress.WithConcurrency(*goroutines), compress.WithBackend(backend)}
+	decoderOpts := []decompress.Option{decompress.WithConcurrency(*goroutines)}
+	if *lz77 {
+		encoderOpts = append(encoderOpts, compress.WithLZ77Window(*window))
+	}
+	if *blockSize != 0 {
+		encoderOpts = append(encoderOpts, compress.WithBlockSize(*blockSize))
+	}
+	if *memoryLimit != 0 {
+		encoderOpts = append(encoderOpts, compress.WithMemoryLimit(int64(*memoryLimit)))
+		decoderOpts = append(decoderOpts, decompress.WithMemoryLimit(int64(*memoryLimit)))
+	}
+	var encoder *compress.Encoder
 	if *encode {
-		fi, err := compress.CompressFile(infile, outfile, *goroutines)
+		encoder, err = compress.NewEncoder(encoderOpts...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, argparser.Usage(err))
+			return
+		}
+	}
+	decoder, err := decompress.NewDecoder(decoderOpts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, argparser.Usage(err))
+		return
+	}
+
+	// Archives are packed from a path and extracted into a directory instead of being streamed
+	if *archive {
+		if *infilePath == STDIO_PATH {
+			fmt.Fprintln(os.Stderr, argparser.Usage("Must specify the path to archive or extract with -i"))
+			return
+		}
+		var inSize, outSize int64
+		if *encode {
+			newWriter := func(w io.Writer) (io.WriteCloser, error) { return encoder.Writer(w), nil }
+			inSize, outSize, err = createArchive(*infilePath, *outfilePath, newWriter)
+		} else {
+			inSize, outSize, err = extractArchive(*infilePath, *outfilePath)
+		}
 		if err != nil {
-			panic(err.Error())
+			exitWithError(err)
+		}
+		logStats(inSize, outSize)
+		return
+	}
+
+	// Open the files
+	infile, err := openInput(*infilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, argparser.Usage(err))
+		return
+	}
+	defer infile.Close()
+	outfile, err := openOutput(*outfilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, argparser.Usage(err))
+		return
+	}
+	defer outfile.Close()
+
+	// An interrupt stops the workers and is reported like any other error
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Regular files are compressed/decompressed in parallel, anything else is streamed
+	var inSize, outSize int64
+	if !isRegular(infile) || !isRegular(outfile) {
+		inSize, outSize, err = streamFile(ctx, infile, outfile, encoder, decoder)
+		if err != nil {
+			exitWithError(err)
+		}
+	} else if *encode {
+		fi, err := encoder.EncodeFileContext(ctx, infile, outfile)
+		if err != nil {
+			exitWithError(err)
+		}
+		if inSize, outSize, err = fileSizes(infile, fi); err != nil {
+			exitWithError(err)
 		}
-		logStats(infile, fi)
 	} else {
-		fi, err := decompress.DecompressFile(infile, outfile, *goroutines)
+		fi, err := decoder.DecodeFileContext(ctx, infile, outfile)
 		if err != nil {
-			panic(err.Error())
+			exitWithError(err)
+		}
+		if inSize, outSize, err = fileSizes(infile, fi); err != nil {
+			exitWithError(err)
 		}
-		logStats(infile, fi)
 	}
+	logStats(inSize, outSize)
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"io.whypeople/huffman/common"
+	"io.whypeople/huffman/decompress"
+)
+
+// Set in the environment of a test binary that should run main instead of the tests
+const RUN_MAIN_ENV = "HUFFMAN_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(RUN_MAIN_ENV) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runHuffman runs the command line with some arguments in a child process.
+// Returns what it printed to stdout and stderr, and its exit code
+// t: The test
+// args: The arguments
+func runHuffman(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), RUN_MAIN_ENV+"=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return stdout.String(), stderr.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), 0
+}
+
+// encodeFile compresses data with the command line and returns the path of the compressed file
+// t: The test
+// data: The data to compress
+// args: Extra encoding arguments
+func encodeFile(t *testing.T, data []byte, args ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	inPath, outPath := filepath.Join(dir, "data"), filepath.Join(dir, "data.huf")
+	if err := os.WriteFile(inPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, code := runHuffman(t, append([]string{"-e", "-i", inPath, "-o", outPath}, args...)...); code != 0 {
+		t.Fatalf("encoding exited with %d: %s", code, stderr)
+	}
+	return outPath
+}
+
+// testData returns a few blocks of text
+func testData() []byte {
+	var data bytes.Buffer
+	for i := 0; data.Len() < 2*common.STREAM_BLOCK_SIZE+100; i++ {
+		fmt.Fprintf(&data, "line %d of the data, %s\n", i, strings.Repeat("ab", i%7))
+	}
+	return data.Bytes()
+}
+
+func TestTestFlag(t *testing.T) {
+	path := encodeFile(t, testData())
+	if _, stderr, code := runHuffman(t, "-t", path); code != 0 || !strings.Contains(stderr, "OK") {
+		t.Fatalf("testing a good file exited with %d: %s", code, stderr)
+	}
+
+	stream, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corruptions := map[string][]byte{
+		"flipped payload bit": append([]byte{}, stream...),
+		"truncated":           stream[:len(stream)/2],
+	}
+	corruptions["flipped payload bit"][len(stream)/2] ^= 0x10
+	for name, corrupt := range corruptions {
+		t.Run(name, func(t *testing.T) {
+			corruptPath := filepath.Join(t.TempDir(), "corrupt.huf")
+			if err := os.WriteFile(corruptPath, corrupt, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, stderr, code := runHuffman(t, "-t", corruptPath); code == 0 || strings.Contains(stderr, "OK") {
+				t.Fatalf("testing a corrupt file exited with %d: %s", code, stderr)
+			}
+		})
+	}
+}
+
+func TestTestFlagArchive(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "data"), testData(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(dir, "src.huf")
+	if _, stderr, code := runHuffman(t, "-e", "--archive", "-i", src, "-o", archivePath); code != 0 {
+		t.Fatalf("archiving exited with %d: %s", code, stderr)
+	}
+	if _, stderr, code := runHuffman(t, "-t", archivePath); code != 0 {
+		t.Fatalf("testing a good archive exited with %d: %s", code, stderr)
+	}
+
+	// The file's data is near the start, well before the directory
+	archive, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive[200] ^= 0x01
+	if err := os.WriteFile(archivePath, archive, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, code := runHuffman(t, "-t", archivePath); code == 0 {
+		t.Fatalf("testing a corrupt archive exited with 0: %s", stderr)
+	}
+}
+
+// listFields parses the "Name: value" lines -l prints
+// out: What -l printed
+func listFields(out string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if parts := strings.SplitN(line, ": ", 2); len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+	return fields
+}
+
+func TestListFlag(t *testing.T) {
+	data := testData()
+	path := encodeFile(t, data)
+	stdout, stderr, code := runHuffman(t, "-l", path)
+	if code != 0 {
+		t.Fatalf("listing exited with %d: %s", code, stderr)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := listFields(stdout)
+	want := map[string]string{
+		"Format":               decompress.FORMAT_STREAM,
+		"Magic Number":         fmt.Sprintf("0x%X", common.STREAM_MAGIC_NUMBER),
+		"Version":              fmt.Sprint(common.FORMAT_VERSION),
+		"Max Code Length":      fmt.Sprint(common.MAX_CODE_SIZE),
+		"Alphabet Size":        fmt.Sprint(common.ALPHABET_SIZE),
+		"Blocks":               "3",
+		"Original File Size":   fmt.Sprint(len(data)),
+		"Compressed File Size": fmt.Sprint(info.Size()),
+		"Compression Ratio":    fmt.Sprintf("%.2f", float64(len(data))/float64(info.Size())),
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("%s is %q, expected %q", name, fields[name], value)
+		}
+	}
+	for _, flag := range []string{"checksum", "canonical codes", "block index"} {
+		if !strings.Contains(fields["Flags"], flag) {
+			t.Errorf("flags %q don't include %s", fields["Flags"], flag)
+		}
+	}
+
+	stdout, _, code = runHuffman(t, "-l", path+".missing")
+	if code == 0 {
+		t.Fatalf("listing a missing file exited with 0: %s", stdout)
+	}
+}
+
+func TestListFlagGzip(t *testing.T) {
+	data := testData()
+	path := encodeFile(t, data, "--gzip")
+	stdout, stderr, code := runHuffman(t, "-l", path)
+	if code != 0 {
+		t.Fatalf("listing exited with %d: %s", code, stderr)
+	}
+	fields := listFields(stdout)
+	if fields["Format"] != decompress.FORMAT_GZIP || fields["Original File Size"] != fmt.Sprint(len(data)) {
+		t.Fatalf("a gzip file was listed as %v", fields)
+	}
+}